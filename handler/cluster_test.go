@@ -0,0 +1,127 @@
+package handler
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grepplabs/backstream/registry"
+	"github.com/grepplabs/backstream/ws"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClusterForwarding(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	codec := NewHttpProtoCodec()
+	reg := registry.NewMemoryRegistry()
+
+	newReplica := func() *httptest.Server {
+		mux := http.NewServeMux()
+		server := httptest.NewServer(mux)
+		serve := ws.NewServe(ctx, NewProxyHandler(codec), codec.MessageCodec(), ws.WithServeCluster(reg, server.URL), ws.WithServeClusterSecret([]byte("secret")))
+		mux.HandleFunc("/ws", serve.HandleWS)
+		mux.HandleFunc("/", serve.HandleProxy)
+		return server
+	}
+	replicaA := newReplica()
+	defer replicaA.Close()
+	replicaB := newReplica()
+	defer replicaB.Close()
+
+	agentHandler := NewRecoveryHandler(NewHTTPHandler(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("OK " + r.URL.Path))
+	}, codec), slog.Default())
+	client := ws.NewClient(ctx, "ws"+strings.TrimPrefix(replicaA.URL, "http")+"/ws", agentHandler, codec.MessageCodec(), ws.WithClientID("4711"))
+	_, err := client.GetConn()
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		owners, err := reg.Lookup(ctx, "4711")
+		return err == nil && len(owners) == 1
+	}, 3*time.Second, 10*time.Millisecond)
+
+	for _, replica := range []*httptest.Server{replicaA, replicaB} {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, replica.URL+"/test", nil)
+		require.NoError(t, err)
+		req.Header.Set(ws.HeaderClientId, "4711")
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		body, err := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		require.Equal(t, "OK /test", string(body))
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, replicaB.URL+"/test", nil)
+	require.NoError(t, err)
+	req.Header.Set(ws.HeaderClientId, "4712")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	require.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+}
+
+func TestClusterForwardingWithoutSecret(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	codec := NewHttpProtoCodec()
+	reg := registry.NewMemoryRegistry()
+
+	newReplica := func() *httptest.Server {
+		mux := http.NewServeMux()
+		server := httptest.NewServer(mux)
+		serve := ws.NewServe(ctx, NewProxyHandler(codec), codec.MessageCodec(), ws.WithServeCluster(reg, server.URL),
+			ws.WithServeClientIDResolver(ws.NewPathPrefixResolver("/c/")))
+		mux.HandleFunc("/ws", serve.HandleWS)
+		mux.HandleFunc("/", serve.HandleProxy)
+		return server
+	}
+	replicaA := newReplica()
+	defer replicaA.Close()
+	replicaB := newReplica()
+	defer replicaB.Close()
+
+	agentHandler := NewRecoveryHandler(NewHTTPHandler(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("OK " + r.URL.Path))
+	}, codec), slog.Default())
+	client := ws.NewClient(ctx, "ws"+strings.TrimPrefix(replicaA.URL, "http")+"/ws", agentHandler, codec.MessageCodec(), ws.WithClientID("4711"))
+	_, err := client.GetConn()
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		owners, err := reg.Lookup(ctx, "4711")
+		return err == nil && len(owners) == 1
+	}, 3*time.Second, 10*time.Millisecond)
+
+	doRequest := func(url string) (int, string) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		require.NoError(t, err)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp.StatusCode, string(body)
+	}
+	// the owner resolves the client ID from the forwarded request
+	for _, replica := range []*httptest.Server{replicaA, replicaB} {
+		status, body := doRequest(replica.URL + "/c/4711/test")
+		require.Equal(t, http.StatusOK, status)
+		require.Equal(t, "OK /test", body)
+	}
+
+	// stale leases do not forward the request in a loop
+	require.NoError(t, reg.Register(ctx, "4712", replicaA.URL, time.Minute))
+	require.NoError(t, reg.Register(ctx, "4712", replicaB.URL, time.Minute))
+	status, _ := doRequest(replicaA.URL + "/c/4712/test")
+	require.Equal(t, http.StatusUnprocessableEntity, status)
+}
@@ -0,0 +1,292 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grepplabs/backstream/internal/message"
+	"github.com/grepplabs/backstream/ws"
+	"google.golang.org/protobuf/proto"
+)
+
+// HeaderEncrypted marks the proxy requests and responses whose body is the encrypted HTTP event.
+// The proxy forwards such bodies to the agent as they are.
+const HeaderEncrypted = "x-backstream-encrypted"
+
+const (
+	// the encrypted request starts with the random request ID and the unix milli timestamp.
+	encryptionIDSize     = 16
+	encryptionHeaderSize = encryptionIDSize + 8
+	// encrypted requests outside of the window are rejected, the request IDs are remembered for the window.
+	encryptionReplayWindow = time.Minute
+)
+
+var ErrClientIDMissing = errors.New("client ID is missing")
+
+// KeyProvider returns the AES key of the agent with the client ID.
+type KeyProvider func(clientID string) ([]byte, error)
+
+// StaticKey returns the key provider with the same key for all client IDs.
+func StaticKey(key []byte) KeyProvider {
+	return func(string) ([]byte, error) {
+		return key, nil
+	}
+}
+
+func newAEAD(keys KeyProvider, clientID string) (cipher.AEAD, error) {
+	key, err := keys(clientID)
+	if err != nil {
+		return nil, fmt.Errorf("encryption key of client ID '%s': %w", clientID, err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("encryption key of client ID '%s': %w", clientID, err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// additionalData binds the ciphertext to the direction, the client ID, the request ID and the timestamp.
+func additionalData(direction string, clientID string, header []byte) []byte {
+	return append([]byte("backstream.v1."+direction+"\x00"+clientID+"\x00"), header...)
+}
+
+type httpEncryptingCodec struct {
+	requestCodec  ws.Codec[*message.EventHTTPRequest]
+	responseCodec ws.Codec[*message.EventHTTPResponse]
+	messageCodec  ws.Codec[*message.Message]
+}
+
+// newHttpEncryptingCodec encrypts the HTTP request and the response event of one request with AES-GCM.
+func newHttpEncryptingCodec(codec HttpCodec, aead cipher.AEAD, clientID string, header []byte) HttpCodec {
+	return &httpEncryptingCodec{
+		requestCodec:  ws.NewEncryptingCodec(codec.RequestCodec(), aead, additionalData("request", clientID, header)),
+		responseCodec: ws.NewEncryptingCodec(codec.ResponseCodec(), aead, additionalData("response", clientID, header)),
+		messageCodec:  codec.MessageCodec(),
+	}
+}
+
+func (c httpEncryptingCodec) RequestCodec() ws.Codec[*message.EventHTTPRequest] {
+	return c.requestCodec
+}
+
+func (c httpEncryptingCodec) ResponseCodec() ws.Codec[*message.EventHTTPResponse] {
+	return c.responseCodec
+}
+
+func (c httpEncryptingCodec) MessageCodec() ws.Codec[*message.Message] {
+	return c.messageCodec
+}
+
+// httpDecryption decrypts the requests of the agent and rejects the replayed ones.
+type httpDecryption struct {
+	clientID string
+	keys     KeyProvider
+	window   time.Duration
+
+	mu     sync.Mutex
+	seen   map[string]time.Time
+	pruned time.Time
+}
+
+func newHttpDecryption(clientID string, keys KeyProvider) *httpDecryption {
+	return &httpDecryption{
+		clientID: clientID,
+		keys:     keys,
+		window:   encryptionReplayWindow,
+		seen:     make(map[string]time.Time),
+	}
+}
+
+// open returns the codec of the encrypted request and the ciphertext of the request event.
+// The codec rejects the replayed requests after they were authenticated.
+func (d *httpDecryption) open(codec HttpCodec, event []byte) (HttpCodec, []byte, error) {
+	if len(event) < encryptionHeaderSize {
+		return nil, nil, fmt.Errorf("%w: encrypted request too short", ws.ErrDecryptionFailed)
+	}
+	header := event[:encryptionHeaderSize]
+	aead, err := newAEAD(d.keys, d.clientID)
+	if err != nil {
+		return nil, nil, err
+	}
+	requestCodec := newHttpEncryptingCodec(codec, aead, d.clientID, header).(*httpEncryptingCodec)
+	requestCodec.requestCodec = &checkedCodec[*message.EventHTTPRequest]{
+		Codec: requestCodec.requestCodec,
+		check: func() error {
+			return d.checkReplay(header, time.Now())
+		},
+	}
+	return requestCodec, event[encryptionHeaderSize:], nil
+}
+
+func (d *httpDecryption) checkReplay(header []byte, now time.Time) error {
+	timestamp := time.UnixMilli(int64(binary.BigEndian.Uint64(header[encryptionIDSize:])))
+	if timestamp.Before(now.Add(-d.window)) || timestamp.After(now.Add(d.window)) {
+		return ws.ErrMessageExpired
+	}
+	id := hex.EncodeToString(header[:encryptionIDSize])
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if now.Sub(d.pruned) > d.window {
+		for seen, expires := range d.seen {
+			if now.After(expires) {
+				delete(d.seen, seen)
+			}
+		}
+		d.pruned = now
+	}
+	if expires, ok := d.seen[id]; ok && !now.After(expires) {
+		return ws.ErrMessageReplayed
+	}
+	d.seen[id] = now.Add(2 * d.window)
+	return nil
+}
+
+func (d *httpDecryption) handleRequest(ctx context.Context, handler http.HandlerFunc, event []byte, codec HttpCodec, defaultRequestTimeout time.Duration) ([]byte, error) {
+	requestCodec, ciphertext, err := d.open(codec, event)
+	if err == nil {
+		var output []byte
+		if output, err = HttpRequestHandler(ctx, handler, ciphertext, requestCodec, defaultRequestTimeout); err == nil {
+			return output, nil
+		}
+	}
+	d.logRejected(err)
+	return nil, err
+}
+
+func (d *httpDecryption) handleNotify(ctx context.Context, handler http.HandlerFunc, event []byte, codec HttpCodec, defaultRequestTimeout time.Duration) error {
+	requestCodec, ciphertext, err := d.open(codec, event)
+	if err == nil {
+		if err = HttpNotifyHandler(ctx, handler, ciphertext, requestCodec, defaultRequestTimeout); err == nil {
+			return nil
+		}
+	}
+	d.logRejected(err)
+	return err
+}
+
+// logRejected logs the requests which could not be decrypted, the agent does not respond to them.
+func (d *httpDecryption) logRejected(err error) {
+	slog.Warn("encrypted request rejected", slog.String("client-id", d.clientID), slog.String("error", err.Error()))
+}
+
+// checkedCodec runs the check after the value was decoded.
+type checkedCodec[T proto.Message] struct {
+	ws.Codec[T]
+	check func() error
+}
+
+func (c *checkedCodec[T]) Decode(data []byte, v T) error {
+	if err := c.Codec.Decode(data, v); err != nil {
+		return err
+	}
+	return c.check()
+}
+
+type encryptingTransport struct {
+	base     http.RoundTripper
+	codec    HttpCodec
+	keys     KeyProvider
+	clientID func(*http.Request) string
+}
+
+// NewEncryptingTransport returns the transport of the callers sending the requests to an agent through the proxy.
+// The request is encrypted with the AES key of the agent client ID, the proxy sees only the method, the URL and
+// the x-backstream-* headers used for the routing. The clientID function returns the client ID of the request,
+// nil takes it from the x-backstream-client-id header. The codec must match the codec of the agent connection.
+func NewEncryptingTransport(base http.RoundTripper, codec HttpCodec, keys KeyProvider, clientID func(*http.Request) string) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	if clientID == nil {
+		clientID = func(r *http.Request) string {
+			return r.Header.Get(ws.HeaderClientId)
+		}
+	}
+	return &encryptingTransport{
+		base:     base,
+		codec:    codec,
+		keys:     keys,
+		clientID: clientID,
+	}
+}
+
+func (t *encryptingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	clientID := t.clientID(req)
+	if clientID == "" {
+		return nil, ErrClientIDMissing
+	}
+	aead, err := newAEAD(t.keys, clientID)
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, encryptionHeaderSize)
+	if _, err = rand.Read(header[:encryptionIDSize]); err != nil {
+		return nil, err
+	}
+	binary.BigEndian.PutUint64(header[encryptionIDSize:], uint64(time.Now().UnixMilli()))
+	codec := newHttpEncryptingCodec(t.codec, aead, clientID, header)
+
+	event, err := fromHttpRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	data, err := codec.RequestCodec().Encode(event)
+	if err != nil {
+		return nil, err
+	}
+	outReq, err := http.NewRequestWithContext(req.Context(), req.Method, req.URL.String(), io.MultiReader(bytes.NewReader(header), bytes.NewReader(data)))
+	if err != nil {
+		return nil, err
+	}
+	outReq.ContentLength = int64(len(header) + len(data))
+	outReq.Host = req.Host
+	for key, values := range req.Header {
+		if strings.HasPrefix(strings.ToLower(key), "x-backstream-") {
+			outReq.Header[key] = values
+		}
+	}
+	outReq.Header.Set(HeaderEncrypted, "true")
+	outReq.Header.Set("Content-Type", "application/octet-stream")
+
+	resp, err := t.base.RoundTrip(outReq)
+	if err != nil {
+		return nil, err
+	}
+	// errors of the proxy are not encrypted
+	if resp.Header.Get(HeaderEncrypted) == "" {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	var outputEvent message.EventHTTPResponse
+	if err = codec.ResponseCodec().Decode(body, &outputEvent); err != nil {
+		return nil, err
+	}
+	result, err := toHttpResponse(&outputEvent)
+	if err != nil {
+		return nil, err
+	}
+	result.Status = fmt.Sprintf("%d %s", result.StatusCode, http.StatusText(result.StatusCode))
+	result.Proto, result.ProtoMajor, result.ProtoMinor = resp.Proto, resp.ProtoMajor, resp.ProtoMinor
+	result.ContentLength = int64(len(outputEvent.Body))
+	result.Request = req
+	return result, nil
+}
@@ -0,0 +1,179 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/grepplabs/backstream/internal/message"
+	"github.com/grepplabs/backstream/ws"
+	"github.com/stretchr/testify/require"
+)
+
+func newKey(t *testing.T) []byte {
+	key := make([]byte, 32)
+	_, err := rand.Read(key)
+	require.NoError(t, err)
+	return key
+}
+
+func encryptRequest(t *testing.T, codec HttpCodec, key []byte, clientID string, timestamp time.Time) ([]byte, HttpCodec) {
+	aead, err := newAEAD(StaticKey(key), clientID)
+	require.NoError(t, err)
+	header := make([]byte, encryptionHeaderSize)
+	_, err = rand.Read(header[:encryptionIDSize])
+	require.NoError(t, err)
+	binary.BigEndian.PutUint64(header[encryptionIDSize:], uint64(timestamp.UnixMilli()))
+	requestCodec := newHttpEncryptingCodec(codec, aead, clientID, header)
+	data, err := requestCodec.RequestCodec().Encode(&message.EventHTTPRequest{Method: http.MethodPost, RawPath: "/secret", Body: []byte("top secret")})
+	require.NoError(t, err)
+	require.False(t, bytes.Contains(data, []byte("secret")))
+	return append(header, data...), requestCodec
+}
+
+func TestHTTPHandlerEncryption(t *testing.T) {
+	key := newKey(t)
+	codec := NewHttpJsonCodec()
+	h := NewHTTPHandler(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		_, _ = w.Write([]byte(r.URL.Path + " " + string(body)))
+	}, codec, WithHTTPEncryption("4711", StaticKey(key)))
+
+	event, requestCodec := encryptRequest(t, codec, key, "4711", time.Now())
+	output, err := h.HandleRequest(context.Background(), event)
+	require.NoError(t, err)
+	require.False(t, bytes.Contains(output, []byte("secret")))
+	var response message.EventHTTPResponse
+	require.NoError(t, requestCodec.ResponseCodec().Decode(output, &response))
+	require.Equal(t, "/secret top secret", string(response.Body))
+
+	// the response is bound to the request
+	_, otherCodec := encryptRequest(t, codec, key, "4711", time.Now())
+	require.ErrorIs(t, otherCodec.ResponseCodec().Decode(output, &response), ws.ErrDecryptionFailed)
+
+	// replayed and expired requests are rejected
+	_, err = h.HandleRequest(context.Background(), event)
+	require.ErrorIs(t, err, ws.ErrMessageReplayed)
+	require.ErrorIs(t, h.HandleNotify(context.Background(), event), ws.ErrMessageReplayed)
+	event, _ = encryptRequest(t, codec, key, "4711", time.Now().Add(-2*encryptionReplayWindow))
+	_, err = h.HandleRequest(context.Background(), event)
+	require.ErrorIs(t, err, ws.ErrMessageExpired)
+
+	// the request is bound to the client ID
+	event, _ = encryptRequest(t, codec, key, "4712", time.Now())
+	_, err = h.HandleRequest(context.Background(), event)
+	require.ErrorIs(t, err, ws.ErrDecryptionFailed)
+
+	tampered, _ := encryptRequest(t, codec, key, "4711", time.Now())
+	tampered[len(tampered)-1] ^= 1
+	_, err = h.HandleRequest(context.Background(), tampered)
+	require.ErrorIs(t, err, ws.ErrDecryptionFailed)
+	_, err = h.HandleRequest(context.Background(), tampered[:10])
+	require.ErrorIs(t, err, ws.ErrDecryptionFailed)
+
+	event, _ = encryptRequest(t, codec, newKey(t), "4711", time.Now())
+	_, err = h.HandleRequest(context.Background(), event)
+	require.ErrorIs(t, err, ws.ErrDecryptionFailed)
+}
+
+func TestProxyEncryption(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	keys := map[string][]byte{"4711": newKey(t), "4712": newKey(t)}
+	codec := NewHttpProtoCodec()
+	serve := ws.NewServe(ctx, NewProxyHandler(codec), codec.MessageCodec(), ws.WithRequireClientId(false))
+	var proxyLeaks atomic.Int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws", serve.HandleWS)
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		// the proxy must not see the plaintext
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		if bytes.Contains(body, []byte("top secret")) || r.Header.Get("X-Secret") != "" {
+			proxyLeaks.Add(1)
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		rec := httptest.NewRecorder()
+		serve.HandleProxy(rec, r)
+		if bytes.Contains(rec.Body.Bytes(), []byte("top secret")) {
+			proxyLeaks.Add(1)
+		}
+		for k, vs := range rec.Header() {
+			w.Header()[k] = vs
+		}
+		w.WriteHeader(rec.Code)
+		_, _ = w.Write(rec.Body.Bytes())
+	})
+	proxy := httptest.NewServer(mux)
+	defer proxy.Close()
+
+	keyProvider := func(clientID string) ([]byte, error) {
+		if key, ok := keys[clientID]; ok {
+			return key, nil
+		}
+		return nil, errors.New("unknown client ID")
+	}
+	startAgent := func(clientID string, keys KeyProvider) {
+		agentHandler := NewRecoveryHandler(NewHTTPHandler(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			_, _ = w.Write([]byte(clientID + " " + r.URL.RequestURI() + " " + r.Header.Get("X-Secret") + " " + string(body)))
+		}, codec, WithHTTPEncryption(clientID, keys)), slog.Default())
+		client := ws.NewClient(ctx, "ws"+strings.TrimPrefix(proxy.URL, "http")+"/ws", agentHandler, codec.MessageCodec(), ws.WithClientID(clientID))
+		_, err := client.GetConn()
+		require.NoError(t, err)
+	}
+	startAgent("4711", keyProvider)
+	startAgent("4712", StaticKey(keys["4711"]))
+	require.Eventually(t, func() bool {
+		return serve.GetConnByID("4711") != nil && serve.GetConnByID("4712") != nil
+	}, 3*time.Second, 10*time.Millisecond)
+
+	transport := NewEncryptingTransport(nil, codec, keyProvider, nil)
+	doRequest := func(clientID string) (*http.Response, string) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, proxy.URL+"/test?q=1", strings.NewReader("top secret"))
+		require.NoError(t, err)
+		req.Header.Set(ws.HeaderClientId, clientID)
+		req.Header.Set(HeaderRequestTimeout, "1s")
+		req.Header.Set("X-Secret", "header")
+		resp, err := (&http.Client{Transport: transport}).Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp, string(body)
+	}
+	resp, body := doRequest("4711")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "4711 /test?q=1 header top secret", body)
+	require.Equal(t, int32(0), proxyLeaks.Load())
+
+	// agent with a wrong key cannot decrypt the request
+	resp, _ = doRequest("4712")
+	require.NotEqual(t, http.StatusOK, resp.StatusCode)
+
+	// proxy errors are passed through
+	keys["4713"] = newKey(t)
+	resp, _ = doRequest("4713")
+	require.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+
+	// unknown keys and client IDs are rejected by the caller
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, proxy.URL+"/test", nil)
+	require.NoError(t, err)
+	req.Header.Set(ws.HeaderClientId, "4714")
+	_, err = transport.RoundTrip(req)
+	require.ErrorContains(t, err, "unknown client ID")
+	req.Header.Del(ws.HeaderClientId)
+	_, err = transport.RoundTrip(req)
+	require.ErrorIs(t, err, ErrClientIDMissing)
+}
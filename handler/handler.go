@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"log/slog"
 	"net/http"
 	"net/http/httptest"
@@ -14,12 +15,13 @@ import (
 )
 
 const (
-	HeaderRequestTimeout  = "x-backstream-request-timeout"
+	HeaderRequestTimeout  = ws.HeaderRequestTimeout
 	DefaultRequestTimeout = 3 * time.Second
 )
 
 type proxyHandler struct {
 	codec                 HttpCodec
+	subprotocolCodecs     map[string]HttpCodec
 	defaultRequestTimeout time.Duration
 }
 
@@ -31,14 +33,40 @@ func WithProxyDefaultRequestTimeout(timeout time.Duration) ProxyHandlerOption {
 	}
 }
 
+// WithProxySubprotocolCodec sets the codec used for connections with the WebSocket subprotocol.
+// Codecs for the built-in subprotocols are registered by default, the option takes precedence over them.
+func WithProxySubprotocolCodec(subprotocol string, codec HttpCodec) ProxyHandlerOption {
+	return func(c *proxyHandler) {
+		c.subprotocolCodecs[subprotocol] = codec
+	}
+}
+
+// NewProxyHandler returns the handler proxying the HTTP requests with the codec of the connection subprotocol.
+// The codec is used for its own subprotocol and for connections without a known one, the built-in codecs are
+// used only for the subprotocols without a codec.
 func NewProxyHandler(codec HttpCodec, opts ...ProxyHandlerOption) ws.ProxyHandler {
 	h := &proxyHandler{
 		codec:                 codec,
+		subprotocolCodecs:     make(map[string]HttpCodec),
 		defaultRequestTimeout: DefaultRequestTimeout,
 	}
+	if subprotocol := ws.Subprotocol(codec.MessageCodec()); subprotocol != "" {
+		h.subprotocolCodecs[subprotocol] = codec
+	}
 	for _, opt := range opts {
 		opt(h)
 	}
+	builtins := map[string]func() HttpCodec{
+		ws.SubprotocolJsonV1:    NewHttpJsonCodec,
+		ws.SubprotocolProtoV1:   NewHttpProtoCodec,
+		ws.SubprotocolMsgpackV1: NewHttpMsgpackCodec,
+		ws.SubprotocolCborV1:    NewHttpCborCodec,
+	}
+	for subprotocol, newCodec := range builtins {
+		if _, ok := h.subprotocolCodecs[subprotocol]; !ok {
+			h.subprotocolCodecs[subprotocol] = newCodec()
+		}
+	}
 	return h
 }
 
@@ -51,7 +79,14 @@ func (h *proxyHandler) HandleNotify(_ context.Context, _ []byte) error {
 }
 
 func (h *proxyHandler) ProxyRequest(conn *ws.Conn, w http.ResponseWriter, r *http.Request) error {
-	return ProxyHttpRequest(conn, w, r, h.codec, h.defaultRequestTimeout)
+	codec := h.codec
+	if c, ok := h.subprotocolCodecs[conn.Subprotocol()]; ok {
+		codec = c
+	}
+	if r.Header.Get(HeaderEncrypted) != "" {
+		return ProxyEncryptedRequest(conn, w, r, h.defaultRequestTimeout)
+	}
+	return ProxyHttpRequest(conn, w, r, codec, h.defaultRequestTimeout)
 }
 
 func GetRequestTimeout(r *http.Request, defaultRequestTimeout time.Duration) (time.Duration, error) {
@@ -100,6 +135,36 @@ func ProxyHttpRequest(conn *ws.Conn, w http.ResponseWriter, r *http.Request, cod
 	return writeHttpResponse(w, &outputEvent)
 }
 
+// ProxyEncryptedRequest sends the body of the request encrypted by the caller to the agent as it is
+// and responds with the encrypted response of the agent, see NewEncryptingTransport.
+func ProxyEncryptedRequest(conn *ws.Conn, w http.ResponseWriter, r *http.Request, defaultRequestTimeout time.Duration) error {
+	defer r.Body.Close()
+	input, err := io.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+
+	ctx := r.Context()
+	requestTimeout, err := GetRequestTimeout(r, defaultRequestTimeout)
+	if err != nil {
+		return err
+	}
+	if requestTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, requestTimeout)
+		defer cancel()
+	}
+
+	output, err := conn.Send(ctx, input)
+	if err != nil {
+		return err
+	}
+	w.Header().Set(HeaderEncrypted, "true")
+	w.Header().Set("Content-Type", "application/octet-stream")
+	_, err = w.Write(output)
+	return err
+}
+
 type recoveryHandler struct {
 	target ws.EventHandler
 	logger *slog.Logger
@@ -134,6 +199,8 @@ type HTTPHandler struct {
 	handlerFunc           http.HandlerFunc
 	codec                 HttpCodec
 	defaultRequestTimeout time.Duration
+	concurrencyLimits     []*concurrencyLimit
+	encryption            *httpDecryption
 }
 
 type HTTPHandlerOption func(*HTTPHandler)
@@ -144,6 +211,31 @@ func WithHTTPDefaultRequestTimeout(timeout time.Duration) HTTPHandlerOption {
 	}
 }
 
+// WithHTTPEncryption decrypts the HTTP requests and encrypts the responses with the AES key of the agent client ID.
+// Requests which cannot be decrypted, expired or replayed requests are logged and rejected.
+func WithHTTPEncryption(clientID string, keys KeyProvider) HTTPHandlerOption {
+	return func(c *HTTPHandler) {
+		c.encryption = newHttpDecryption(clientID, keys)
+	}
+}
+
+// WithHTTPConcurrencyLimit limits the number of concurrently handled requests with the method and the path prefix,
+// an empty method matches all methods. Only the first matching limit applies, requests exceeding it get 503.
+// A limit less than or equal to zero is ignored.
+func WithHTTPConcurrencyLimit(method string, pathPrefix string, limit int) HTTPHandlerOption {
+	return func(c *HTTPHandler) {
+		if limit <= 0 {
+			slog.Warn("ignoring non-positive HTTP concurrency limit", slog.String("method", method), slog.String("path-prefix", pathPrefix), slog.Int("limit", limit))
+			return
+		}
+		c.concurrencyLimits = append(c.concurrencyLimits, &concurrencyLimit{
+			method:     method,
+			pathPrefix: pathPrefix,
+			sem:        make(chan struct{}, limit),
+		})
+	}
+}
+
 func NewHTTPHandler(handlerFunc http.HandlerFunc, codec HttpCodec, opts ...HTTPHandlerOption) *HTTPHandler {
 	h := &HTTPHandler{
 		handlerFunc:           handlerFunc,
@@ -153,14 +245,23 @@ func NewHTTPHandler(handlerFunc http.HandlerFunc, codec HttpCodec, opts ...HTTPH
 	for _, opt := range opts {
 		opt(h)
 	}
+	if len(h.concurrencyLimits) != 0 {
+		h.handlerFunc = limitConcurrency(h.concurrencyLimits, h.handlerFunc)
+	}
 	return h
 }
 
 func (h *HTTPHandler) HandleRequest(ctx context.Context, event []byte) ([]byte, error) {
+	if h.encryption != nil {
+		return h.encryption.handleRequest(ctx, h.handlerFunc, event, h.codec, h.defaultRequestTimeout)
+	}
 	return HttpRequestHandler(ctx, h.handlerFunc, event, h.codec, h.defaultRequestTimeout)
 }
 
 func (h *HTTPHandler) HandleNotify(ctx context.Context, event []byte) error {
+	if h.encryption != nil {
+		return h.encryption.handleNotify(ctx, h.handlerFunc, event, h.codec, h.defaultRequestTimeout)
+	}
 	return HttpNotifyHandler(ctx, h.handlerFunc, event, h.codec, h.defaultRequestTimeout)
 }
 
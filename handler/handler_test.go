@@ -89,6 +89,16 @@ func TestHttpProxyRequest(t *testing.T) {
 			codec: NewHttpProtoCodec(),
 			tls:   false,
 		},
+		{
+			name:  "http msgpack codec",
+			codec: NewHttpMsgpackCodec(),
+			tls:   false,
+		},
+		{
+			name:  "http cbor codec",
+			codec: NewHttpCborCodec(),
+			tls:   false,
+		},
 		{
 			name:  "https json codec",
 			codec: NewHttpJsonCodec(),
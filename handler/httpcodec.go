@@ -62,3 +62,55 @@ func (c httpProtoCodec) ResponseCodec() ws.Codec[*message.EventHTTPResponse] {
 func (c httpProtoCodec) MessageCodec() ws.Codec[*message.Message] {
 	return c.messageCodec
 }
+
+type httpMsgpackCodec struct {
+	requestCodec  ws.Codec[*message.EventHTTPRequest]
+	responseCodec ws.Codec[*message.EventHTTPResponse]
+	messageCodec  ws.Codec[*message.Message]
+}
+
+func NewHttpMsgpackCodec() HttpCodec {
+	return &httpMsgpackCodec{
+		requestCodec:  ws.NewMsgpackCodec[*message.EventHTTPRequest](),
+		responseCodec: ws.NewMsgpackCodec[*message.EventHTTPResponse](),
+		messageCodec:  ws.NewMsgpackCodec[*message.Message](),
+	}
+}
+
+func (c httpMsgpackCodec) RequestCodec() ws.Codec[*message.EventHTTPRequest] {
+	return c.requestCodec
+}
+
+func (c httpMsgpackCodec) ResponseCodec() ws.Codec[*message.EventHTTPResponse] {
+	return c.responseCodec
+}
+
+func (c httpMsgpackCodec) MessageCodec() ws.Codec[*message.Message] {
+	return c.messageCodec
+}
+
+type httpCborCodec struct {
+	requestCodec  ws.Codec[*message.EventHTTPRequest]
+	responseCodec ws.Codec[*message.EventHTTPResponse]
+	messageCodec  ws.Codec[*message.Message]
+}
+
+func NewHttpCborCodec() HttpCodec {
+	return &httpCborCodec{
+		requestCodec:  ws.NewCborCodec[*message.EventHTTPRequest](),
+		responseCodec: ws.NewCborCodec[*message.EventHTTPResponse](),
+		messageCodec:  ws.NewCborCodec[*message.Message](),
+	}
+}
+
+func (c httpCborCodec) RequestCodec() ws.Codec[*message.EventHTTPRequest] {
+	return c.requestCodec
+}
+
+func (c httpCborCodec) ResponseCodec() ws.Codec[*message.EventHTTPResponse] {
+	return c.responseCodec
+}
+
+func (c httpCborCodec) MessageCodec() ws.Codec[*message.Message] {
+	return c.messageCodec
+}
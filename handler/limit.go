@@ -0,0 +1,36 @@
+package handler
+
+import (
+	"net/http"
+	"strings"
+)
+
+type concurrencyLimit struct {
+	method     string
+	pathPrefix string
+	sem        chan struct{}
+}
+
+func (l *concurrencyLimit) matches(r *http.Request) bool {
+	return (l.method == "" || l.method == r.Method) && strings.HasPrefix(r.URL.Path, l.pathPrefix)
+}
+
+// limitConcurrency responds with 503 when the first limit matching the request is exhausted.
+func limitConcurrency(limits []*concurrencyLimit, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		for _, limit := range limits {
+			if !limit.matches(r) {
+				continue
+			}
+			select {
+			case limit.sem <- struct{}{}:
+				defer func() { <-limit.sem }()
+			default:
+				http.Error(w, "too many concurrent requests", http.StatusServiceUnavailable)
+				return
+			}
+			break
+		}
+		next(w, r)
+	}
+}
@@ -0,0 +1,100 @@
+package handler
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grepplabs/backstream/ws"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHTTPConcurrencyLimit(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	codec := NewHttpProtoCodec()
+	proxy, serve := newRoutingProxy(ctx, codec)
+	defer proxy.Close()
+
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	agentHandler := NewRecoveryHandler(NewHTTPHandler(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			started <- struct{}{}
+			<-release
+		}
+		_, _ = w.Write([]byte(r.Method + " " + r.URL.Path))
+	}, codec, WithHTTPConcurrencyLimit(http.MethodGet, "/slow", 1)), slog.Default())
+	client := ws.NewClient(ctx, "ws"+strings.TrimPrefix(proxy.URL, "http")+"/ws", agentHandler, codec.MessageCodec(), ws.WithClientID("4711"))
+	_, err := client.GetConn()
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		return serve.GetConnByID("4711") != nil
+	}, 3*time.Second, 10*time.Millisecond)
+
+	header := http.Header{ws.HeaderClientId: {"4711"}}
+	done := make(chan int)
+	go func() {
+		status, _ := doRoutingRequest(t, ctx, proxy.URL+"/slow", header)
+		done <- status
+	}()
+	<-started
+
+	status, body := doRoutingRequest(t, ctx, proxy.URL+"/slow", header)
+	require.Equal(t, http.StatusServiceUnavailable, status)
+	require.Contains(t, body, "too many concurrent requests")
+
+	status, body = doRoutingRequest(t, ctx, proxy.URL+"/fast", header)
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, "GET /fast", body)
+
+	close(release)
+	require.Equal(t, http.StatusOK, <-done)
+}
+
+func TestAgentOverloaded(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	codec := NewHttpProtoCodec()
+	proxy, serve := newRoutingProxy(ctx, codec)
+	defer proxy.Close()
+
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	agentHandler := NewRecoveryHandler(NewHTTPHandler(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+	}, codec), slog.Default())
+	client := ws.NewClient(ctx, "ws"+strings.TrimPrefix(proxy.URL, "http")+"/ws", agentHandler, codec.MessageCodec(), ws.WithClientID("4711"), ws.WithClientConcurrencyLimit(1, 0))
+	_, err := client.GetConn()
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		return serve.GetConnByID("4711") != nil
+	}, 3*time.Second, 10*time.Millisecond)
+
+	header := http.Header{ws.HeaderClientId: {"4711"}}
+	done := make(chan int)
+	go func() {
+		status, _ := doRoutingRequest(t, ctx, proxy.URL+"/test", header)
+		done <- status
+	}()
+	<-started
+
+	status, body := doRoutingRequest(t, ctx, proxy.URL+"/test", header)
+	require.Equal(t, http.StatusServiceUnavailable, status)
+	require.Contains(t, body, "agent overloaded")
+
+	close(release)
+	require.Equal(t, http.StatusOK, <-done)
+}
+
+func TestHTTPConcurrencyLimitNonPositive(t *testing.T) {
+	h := NewHTTPHandler(func(w http.ResponseWriter, r *http.Request) {}, NewHttpProtoCodec(),
+		WithHTTPConcurrencyLimit("", "/", 0), WithHTTPConcurrencyLimit("", "/", -1))
+	require.Empty(t, h.concurrencyLimits)
+}
@@ -0,0 +1,115 @@
+package handler
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grepplabs/backstream/internal/message"
+	"github.com/grepplabs/backstream/ws"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandleProxyWithRetry(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	codec := NewHttpProtoCodec()
+	mux := http.NewServeMux()
+	proxy := httptest.NewServer(mux)
+	defer proxy.Close()
+	serve := ws.NewServe(ctx, NewProxyHandler(codec), codec.MessageCodec(),
+		ws.WithServeRetryPolicy(ws.RetryPolicy{MaxAttempts: 2, InitialBackoff: 10 * time.Millisecond, MaxBackoff: 10 * time.Millisecond, MaxBodySize: 1024}))
+	mux.HandleFunc("/ws", serve.HandleWS)
+	mux.HandleFunc("/", serve.HandleProxyWithRetry)
+
+	// busy agent rejects all requests with 503
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	defer close(release)
+	busyHandler := NewHTTPHandler(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+	}, codec)
+	busy := ws.NewClient(ctx, "ws"+strings.TrimPrefix(proxy.URL, "http")+"/ws", busyHandler, codec.MessageCodec(), ws.WithClientID("4711"), ws.WithClientConcurrencyLimit(1, 0))
+	_, err := busy.GetConn()
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		return serve.GetConnByID("4711") != nil
+	}, 3*time.Second, 10*time.Millisecond)
+	busyConn := serve.GetConnByID("4711")
+	busyRequest, err := codec.RequestCodec().Encode(&message.EventHTTPRequest{Method: http.MethodGet, RawPath: "/busy"})
+	require.NoError(t, err)
+	go func() {
+		_, _ = busyConn.Send(ctx, busyRequest)
+	}()
+	<-started
+
+	echoHandler := NewRecoveryHandler(NewHTTPHandler(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("x-agent", "echo")
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte(r.Method + " " + string(body)))
+	}, codec), slog.Default())
+	echo := ws.NewClient(ctx, "ws"+strings.TrimPrefix(proxy.URL, "http")+"/ws", echoHandler, codec.MessageCodec(), ws.WithClientID("4711"))
+	_, err = echo.GetConn()
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		return len(serve.GetConnsByID("4711")) == 2
+	}, 3*time.Second, 10*time.Millisecond)
+
+	doRequest := func(method string, body string, header http.Header) (int, string) {
+		req, err := http.NewRequestWithContext(ctx, method, proxy.URL+"/test", strings.NewReader(body))
+		require.NoError(t, err)
+		req.Header = header
+		req.Header.Set(ws.HeaderClientId, "4711")
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		output, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		if resp.StatusCode == http.StatusCreated {
+			require.Equal(t, "echo", resp.Header.Get("x-agent"))
+		}
+		return resp.StatusCode, string(output)
+	}
+
+	statuses := make(map[int]int)
+	for i := 0; i < 20; i++ {
+		status, body := doRequest(http.MethodGet, "", http.Header{})
+		require.Equal(t, http.StatusCreated, status)
+		require.Equal(t, "GET ", body)
+
+		status, body = doRequest(http.MethodPut, "payload", http.Header{})
+		require.Equal(t, http.StatusCreated, status)
+		require.Equal(t, "PUT payload", body)
+
+		status, body = doRequest(http.MethodPost, "payload", http.Header{ws.HeaderIdempotencyKey: {"key"}})
+		require.Equal(t, http.StatusCreated, status)
+		require.Equal(t, "POST payload", body)
+
+		status, _ = doRequest(http.MethodPost, "payload", http.Header{})
+		statuses[status]++
+	}
+	// non-idempotent requests are not retried
+	require.Len(t, statuses, 2)
+	require.Equal(t, 20, statuses[http.StatusCreated]+statuses[http.StatusServiceUnavailable])
+
+	// body exceeding the buffer is not replayed
+	large := strings.Repeat("x", 2048)
+	statuses = make(map[int]int)
+	for i := 0; i < 20; i++ {
+		status, body := doRequest(http.MethodPut, large, http.Header{})
+		if status == http.StatusCreated {
+			require.Equal(t, "PUT "+large, body)
+		}
+		statuses[status]++
+	}
+	require.Len(t, statuses, 2)
+	require.Equal(t, 20, statuses[http.StatusCreated]+statuses[http.StatusServiceUnavailable])
+}
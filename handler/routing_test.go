@@ -0,0 +1,257 @@
+package handler
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/grepplabs/backstream/internal/message"
+	"github.com/grepplabs/backstream/ws"
+	"github.com/stretchr/testify/require"
+)
+
+func newRoutingProxy(ctx context.Context, codec HttpCodec, opts ...ws.ServeOption) (*httptest.Server, *ws.Serve) {
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	serve := ws.NewServe(ctx, NewProxyHandler(codec), codec.MessageCodec(), opts...)
+	mux.HandleFunc("/ws", serve.HandleWS)
+	mux.HandleFunc("/", serve.HandleProxy)
+	return server, serve
+}
+
+func startRoutingAgent(t *testing.T, ctx context.Context, codec HttpCodec, proxyURL string, name string, opts ...ws.ClientOption) {
+	agentHandler := NewRecoveryHandler(NewHTTPHandler(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(name + " " + r.URL.RequestURI()))
+	}, codec), slog.Default())
+	client := ws.NewClient(ctx, "ws"+strings.TrimPrefix(proxyURL, "http")+"/ws", agentHandler, codec.MessageCodec(), opts...)
+	_, err := client.GetConn()
+	require.NoError(t, err)
+}
+
+func doRoutingRequest(t *testing.T, ctx context.Context, url string, header http.Header) (int, string) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	require.NoError(t, err)
+	for k, vs := range header {
+		req.Header[k] = vs
+	}
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp.StatusCode, string(body)
+}
+
+func TestLabelSelectorRouting(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	codec := NewHttpProtoCodec()
+	proxy, serve := newRoutingProxy(ctx, codec, ws.WithRequireClientId(false))
+	defer proxy.Close()
+
+	startRoutingAgent(t, ctx, codec, proxy.URL, "eu-db", ws.WithClientID("4711"), ws.WithClientLabels(map[string]string{"region": "eu", "role": "db"}))
+	startRoutingAgent(t, ctx, codec, proxy.URL, "us-db", ws.WithClientID("4712"), ws.WithClientLabels(map[string]string{"region": "us", "role": "db"}))
+	require.Eventually(t, func() bool {
+		return serve.GetConnByID("4711") != nil && serve.GetConnByID("4712") != nil
+	}, 3*time.Second, 10*time.Millisecond)
+	require.Equal(t, map[string]string{"region": "eu", "role": "db"}, serve.GetConnByID("4711").Labels())
+
+	status, body := doRoutingRequest(t, ctx, proxy.URL+"/test", http.Header{ws.HeaderSelector: {"region=us,role=db"}})
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, "us-db /test", body)
+
+	status, body = doRoutingRequest(t, ctx, proxy.URL+"/test", http.Header{ws.HeaderSelector: {"role=db"}, ws.HeaderClientId: {"4711"}})
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, "eu-db /test", body)
+
+	status, _ = doRoutingRequest(t, ctx, proxy.URL+"/test", http.Header{ws.HeaderSelector: {"region=eu"}, ws.HeaderClientId: {"4712"}})
+	require.Equal(t, http.StatusUnprocessableEntity, status)
+
+	status, _ = doRoutingRequest(t, ctx, proxy.URL+"/test", http.Header{ws.HeaderSelector: {"region"}})
+	require.Equal(t, http.StatusBadRequest, status)
+}
+
+func TestClientIDResolverRouting(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	codec := NewHttpJsonCodec()
+	resolver := ws.NewChainResolver(
+		ws.NewHeaderResolver(ws.HeaderClientId),
+		ws.NewHostResolver(".tunnel.example.com"),
+		ws.NewPathPrefixResolver("/c/"),
+		ws.NewQueryResolver("client-id"),
+	)
+	proxy, serve := newRoutingProxy(ctx, codec, ws.WithServeClientIDResolver(resolver))
+	defer proxy.Close()
+
+	startRoutingAgent(t, ctx, codec, proxy.URL, "agent", ws.WithClientID("4711"))
+	require.Eventually(t, func() bool {
+		return serve.GetConnByID("4711") != nil
+	}, 3*time.Second, 10*time.Millisecond)
+
+	tests := []struct {
+		name   string
+		url    string
+		header http.Header
+		status int
+		body   string
+	}{
+		{
+			name:   "header",
+			url:    proxy.URL + "/test?a=1",
+			header: http.Header{ws.HeaderClientId: {"4711"}},
+			status: http.StatusOK,
+			body:   "agent /test?a=1",
+		},
+		{
+			name:   "host",
+			url:    proxy.URL + "/test?a=1",
+			header: http.Header{"Host": {"4711.Tunnel.example.com:8080"}},
+			status: http.StatusOK,
+			body:   "agent /test?a=1",
+		},
+		{
+			name:   "path prefix",
+			url:    proxy.URL + "/c/4711/test/b?a=1",
+			status: http.StatusOK,
+			body:   "agent /test/b?a=1",
+		},
+		{
+			name:   "path prefix root",
+			url:    proxy.URL + "/c/4711",
+			status: http.StatusOK,
+			body:   "agent /",
+		},
+		{
+			name:   "query",
+			url:    proxy.URL + "/test?a=1&client-id=4711",
+			status: http.StatusOK,
+			body:   "agent /test?a=1",
+		},
+		{
+			name:   "unknown client",
+			url:    proxy.URL + "/c/4712/test",
+			status: http.StatusUnprocessableEntity,
+		},
+		{
+			name:   "invalid query",
+			url:    proxy.URL + "/test?a=%zz",
+			status: http.StatusBadRequest,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, tc.url, nil)
+			require.NoError(t, err)
+			for k, vs := range tc.header {
+				req.Header[k] = vs
+			}
+			if host := tc.header.Get("Host"); host != "" {
+				req.Host = host
+			}
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+			body, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			require.Equal(t, tc.status, resp.StatusCode, string(body))
+			if tc.body != "" {
+				require.Equal(t, tc.body, string(body))
+			}
+		})
+	}
+}
+
+func TestSubprotocolCodecs(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	protoCodec := NewHttpProtoCodec()
+	jsonCodec := NewHttpJsonCodec()
+	proxy, serve := newRoutingProxy(ctx, protoCodec, ws.WithServeSubprotocolCodec(ws.SubprotocolJsonV1, jsonCodec.MessageCodec()))
+	defer proxy.Close()
+
+	startRoutingAgent(t, ctx, protoCodec, proxy.URL, "proto", ws.WithClientID("4711"))
+	startRoutingAgent(t, ctx, jsonCodec, proxy.URL, "json", ws.WithClientID("4712"))
+	require.Eventually(t, func() bool {
+		return serve.GetConnByID("4711") != nil && serve.GetConnByID("4712") != nil
+	}, 3*time.Second, 10*time.Millisecond)
+	require.Equal(t, ws.SubprotocolProtoV1, serve.GetConnByID("4711").Subprotocol())
+	require.Equal(t, ws.SubprotocolJsonV1, serve.GetConnByID("4712").Subprotocol())
+	require.Equal(t, []string{ws.CodecNameJson}, serve.GetConnByID("4712").Capabilities().Codecs)
+
+	status, body := doRoutingRequest(t, ctx, proxy.URL+"/test", http.Header{ws.HeaderClientId: {"4711"}})
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, "proto /test", body)
+
+	status, body = doRoutingRequest(t, ctx, proxy.URL+"/test", http.Header{ws.HeaderClientId: {"4712"}})
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, "json /test", body)
+}
+
+func TestForwardedClientIDNotTrusted(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	codec := NewHttpJsonCodec()
+	proxy, serve := newRoutingProxy(ctx, codec, ws.WithServeClientIDResolver(ws.NewHostResolver(".tunnel.example.com")))
+	defer proxy.Close()
+
+	startRoutingAgent(t, ctx, codec, proxy.URL, "agent", ws.WithClientID("4711"))
+	require.Eventually(t, func() bool {
+		return serve.GetConnByID("4711") != nil
+	}, 3*time.Second, 10*time.Millisecond)
+
+	// the resolver is not bypassed by the unsigned forwarded-by header
+	status, _ := doRoutingRequest(t, ctx, proxy.URL+"/test", http.Header{ws.HeaderClientId: {"4711"}, ws.HeaderForwardedBy: {"http://10.0.0.2:8080"}})
+	require.NotEqual(t, http.StatusOK, status)
+}
+
+type countingHttpCodec struct {
+	HttpCodec
+	requests *atomic.Int32
+}
+
+func (c countingHttpCodec) RequestCodec() ws.Codec[*message.EventHTTPRequest] {
+	return countingRequestCodec{Codec: c.HttpCodec.RequestCodec(), requests: c.requests}
+}
+
+type countingRequestCodec struct {
+	ws.Codec[*message.EventHTTPRequest]
+	requests *atomic.Int32
+}
+
+func (c countingRequestCodec) Encode(v *message.EventHTTPRequest) ([]byte, error) {
+	c.requests.Add(1)
+	return c.Codec.Encode(v)
+}
+
+func TestProxyHandlerCustomCodec(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// the wrapped codec uses the built-in proto subprotocol
+	var requests atomic.Int32
+	codec := countingHttpCodec{HttpCodec: NewHttpProtoCodec(), requests: &requests}
+	proxy, serve := newRoutingProxy(ctx, codec)
+	defer proxy.Close()
+
+	startRoutingAgent(t, ctx, NewHttpProtoCodec(), proxy.URL, "agent", ws.WithClientID("4711"))
+	require.Eventually(t, func() bool {
+		return serve.GetConnByID("4711") != nil
+	}, 3*time.Second, 10*time.Millisecond)
+	require.Equal(t, ws.SubprotocolProtoV1, serve.GetConnByID("4711").Subprotocol())
+
+	status, body := doRoutingRequest(t, ctx, proxy.URL+"/test", http.Header{ws.HeaderClientId: {"4711"}})
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, "agent /test", body)
+	require.Equal(t, int32(1), requests.Load())
+}
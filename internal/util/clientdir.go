@@ -0,0 +1,27 @@
+package util
+
+import (
+	"net/url"
+	"path/filepath"
+	"strings"
+)
+
+// prefix of the client ID directories, it prevents "." and ".." directory names as url.PathEscape keeps "." as is.
+const clientDirPrefix = "c-"
+
+// ClientDir returns the directory of the client ID in dir, any client ID is a single safe path element.
+func ClientDir(dir string, clientID string) string {
+	return filepath.Join(dir, clientDirPrefix+url.PathEscape(clientID))
+}
+
+// ClientIDFromDir returns the client ID of the directory name returned by ClientDir, false if it is not one.
+func ClientIDFromDir(name string) (string, bool) {
+	if !strings.HasPrefix(name, clientDirPrefix) {
+		return "", false
+	}
+	clientID, err := url.PathUnescape(strings.TrimPrefix(name, clientDirPrefix))
+	if err != nil {
+		return "", false
+	}
+	return clientID, true
+}
@@ -0,0 +1,22 @@
+package util
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientDir(t *testing.T) {
+	for _, clientID := range []string{"4711", ".", "..", "a/b", `a\b`, "a%2Fb", ""} {
+		dir := ClientDir("/data", clientID)
+		require.Equal(t, "/data", filepath.Dir(dir))
+		id, ok := ClientIDFromDir(filepath.Base(dir))
+		require.True(t, ok)
+		require.Equal(t, clientID, id)
+	}
+	_, ok := ClientIDFromDir("4711")
+	require.False(t, ok)
+	_, ok = ClientIDFromDir("c-%zz")
+	require.False(t, ok)
+}
@@ -0,0 +1,54 @@
+package util
+
+import (
+	"sync"
+)
+
+// KeyedMutex is a mutex per key, callers with different keys do not block each other.
+type KeyedMutex[K comparable] struct {
+	mu    sync.Mutex
+	locks map[K]*keyedLock
+}
+
+type keyedLock struct {
+	mu sync.Mutex
+	// number of holders and waiters, the lock is removed when it drops to zero.
+	refs int
+}
+
+func NewKeyedMutex[K comparable]() *KeyedMutex[K] {
+	return &KeyedMutex[K]{
+		locks: make(map[K]*keyedLock),
+	}
+}
+
+// Lock locks the key and returns the function unlocking it.
+func (m *KeyedMutex[K]) Lock(key K) func() {
+	m.mu.Lock()
+	lock, ok := m.locks[key]
+	if !ok {
+		lock = &keyedLock{}
+		m.locks[key] = lock
+	}
+	lock.refs++
+	m.mu.Unlock()
+
+	lock.mu.Lock()
+	return func() {
+		lock.mu.Unlock()
+
+		m.mu.Lock()
+		lock.refs--
+		if lock.refs == 0 {
+			delete(m.locks, key)
+		}
+		m.mu.Unlock()
+	}
+}
+
+// Size returns the number of locked keys.
+func (m *KeyedMutex[K]) Size() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.locks)
+}
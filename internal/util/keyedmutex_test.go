@@ -0,0 +1,34 @@
+package util
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyedMutex(t *testing.T) {
+	m := NewKeyedMutex[string]()
+
+	unlockA := m.Lock("a")
+	// other keys are not blocked
+	unlockB := m.Lock("b")
+	unlockB()
+
+	locked := make(chan struct{})
+	go func() {
+		unlock := m.Lock("a")
+		close(locked)
+		unlock()
+	}()
+	select {
+	case <-locked:
+		require.Fail(t, "key locked twice")
+	case <-time.After(50 * time.Millisecond):
+	}
+	unlockA()
+	<-locked
+	require.Eventually(t, func() bool {
+		return m.Size() == 0
+	}, time.Second, time.Millisecond)
+}
@@ -0,0 +1,47 @@
+package util
+
+import (
+	"sync"
+)
+
+// RecentSet remembers up to size most recently added keys.
+type RecentSet[K comparable] struct {
+	mu    sync.Mutex
+	size  int
+	keys  map[K]struct{}
+	order []K
+	next  int
+}
+
+func NewRecentSet[K comparable](size int) *RecentSet[K] {
+	return &RecentSet[K]{
+		size:  size,
+		keys:  make(map[K]struct{}, size),
+		order: make([]K, 0, size),
+	}
+}
+
+func (s *RecentSet[K]) Add(key K) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.keys[key]; ok {
+		return
+	}
+	if len(s.order) < s.size {
+		s.order = append(s.order, key)
+	} else {
+		delete(s.keys, s.order[s.next])
+		s.order[s.next] = key
+		s.next = (s.next + 1) % s.size
+	}
+	s.keys[key] = struct{}{}
+}
+
+func (s *RecentSet[K]) Contains(key K) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, ok := s.keys[key]
+	return ok
+}
@@ -0,0 +1,27 @@
+package util
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecentSet(t *testing.T) {
+	rs := NewRecentSet[string](2)
+
+	rs.Add("a")
+	rs.Add("b")
+	rs.Add("a")
+	require.True(t, rs.Contains("a"))
+	require.True(t, rs.Contains("b"))
+
+	rs.Add("c")
+	require.False(t, rs.Contains("a"))
+	require.True(t, rs.Contains("b"))
+	require.True(t, rs.Contains("c"))
+
+	rs.Add("d")
+	require.False(t, rs.Contains("b"))
+	require.True(t, rs.Contains("c"))
+	require.True(t, rs.Contains("d"))
+}
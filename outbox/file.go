@@ -0,0 +1,155 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grepplabs/backstream/internal/util"
+)
+
+const (
+	entrySuffix   = ".json"
+	fileStorePerm = 0o755
+)
+
+// fileStore keeps every entry in a file <dir>/<client-id>/<sequence>-<id>.json.
+// The zero padded sequence preserves the append order in the directory listing.
+type fileStore struct {
+	dir string
+
+	mu      sync.Mutex
+	lastSeq int64
+}
+
+type fileEntry struct {
+	ID      string    `json:"id"`
+	Data    []byte    `json:"data"`
+	Expires time.Time `json:"expires"`
+}
+
+func NewFileStore(dir string) (Store, error) {
+	if err := os.MkdirAll(dir, fileStorePerm); err != nil {
+		return nil, err
+	}
+	return &fileStore{
+		dir: dir,
+	}, nil
+}
+
+func (s *fileStore) Append(_ context.Context, clientID string, entry Entry) error {
+	if entry.ID == "" || strings.ContainsAny(entry.ID, `/\`) {
+		return fmt.Errorf("invalid entry id '%s'", entry.ID)
+	}
+	clientDir := s.clientDir(clientID)
+	if err := os.MkdirAll(clientDir, fileStorePerm); err != nil {
+		return err
+	}
+	data, err := json.Marshal(fileEntry{ID: entry.ID, Data: entry.Data, Expires: entry.Expires})
+	if err != nil {
+		return err
+	}
+	name := filepath.Join(clientDir, fmt.Sprintf("%020d-%s%s", s.nextSeq(), entry.ID, entrySuffix))
+
+	// write and rename, so that List never sees partially written entries
+	tmp, err := os.CreateTemp(clientDir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), name)
+}
+
+func (s *fileStore) List(_ context.Context, clientID string) ([]Entry, error) {
+	clientDir := s.clientDir(clientID)
+	dirEntries, err := os.ReadDir(clientDir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	now := time.Now()
+	var result []Entry
+	for _, dirEntry := range dirEntries {
+		if !strings.HasSuffix(dirEntry.Name(), entrySuffix) {
+			continue
+		}
+		name := filepath.Join(clientDir, dirEntry.Name())
+		data, err := os.ReadFile(name)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return nil, err
+		}
+		var e fileEntry
+		if err = json.Unmarshal(data, &e); err != nil {
+			return nil, fmt.Errorf("outbox entry %s: %w", name, err)
+		}
+		entry := Entry{ID: e.ID, Data: e.Data, Expires: e.Expires}
+		if entry.Expired(now) {
+			_ = os.Remove(name)
+			continue
+		}
+		result = append(result, entry)
+	}
+	return result, nil
+}
+
+func (s *fileStore) Ack(_ context.Context, clientID string, id string) error {
+	names, err := filepath.Glob(filepath.Join(s.clientDir(clientID), "*-"+escapeGlob(id)+entrySuffix))
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if err = os.Remove(name); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *fileStore) nextSeq() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	seq := time.Now().UnixNano()
+	if seq <= s.lastSeq {
+		seq = s.lastSeq + 1
+	}
+	s.lastSeq = seq
+	return seq
+}
+
+func (s *fileStore) clientDir(clientID string) string {
+	return util.ClientDir(s.dir, clientID)
+}
+
+func escapeGlob(s string) string {
+	var b strings.Builder
+	for _, c := range s {
+		if strings.ContainsRune(`*?[\`, c) {
+			b.WriteRune('\\')
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
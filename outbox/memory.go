@@ -0,0 +1,62 @@
+package outbox
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type memoryStore struct {
+	mu      sync.Mutex
+	entries map[string][]Entry
+}
+
+func NewMemoryStore() Store {
+	return &memoryStore{
+		entries: make(map[string][]Entry),
+	}
+}
+
+func (s *memoryStore) Append(_ context.Context, clientID string, entry Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.entries[clientID] = append(s.entries[clientID], entry)
+	return nil
+}
+
+func (s *memoryStore) List(_ context.Context, clientID string) ([]Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	var result []Entry
+	for _, entry := range s.entries[clientID] {
+		if !entry.Expired(now) {
+			result = append(result, entry)
+		}
+	}
+	if len(result) == 0 {
+		delete(s.entries, clientID)
+	} else {
+		s.entries[clientID] = result
+	}
+	return append([]Entry(nil), result...), nil
+}
+
+func (s *memoryStore) Ack(_ context.Context, clientID string, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries := s.entries[clientID]
+	for i, entry := range entries {
+		if entry.ID == id {
+			s.entries[clientID] = append(entries[:i:i], entries[i+1:]...)
+			break
+		}
+	}
+	if len(s.entries[clientID]) == 0 {
+		delete(s.entries, clientID)
+	}
+	return nil
+}
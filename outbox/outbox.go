@@ -0,0 +1,28 @@
+package outbox
+
+import (
+	"context"
+	"time"
+)
+
+type Entry struct {
+	ID string
+	// Data is the notification payload.
+	Data []byte
+	// Expires is the time after which the entry is dropped, zero value means no expiry.
+	Expires time.Time
+}
+
+func (e *Entry) Expired(now time.Time) bool {
+	return !e.Expires.IsZero() && !now.Before(e.Expires)
+}
+
+// Store is a durable queue of notifications per client ID.
+type Store interface {
+	// Append adds the entry to the end of the client ID queue.
+	Append(ctx context.Context, clientID string, entry Entry) error
+	// List returns unexpired entries of the client ID queue in the append order.
+	List(ctx context.Context, clientID string) ([]Entry, error)
+	// Ack removes the acknowledged entry from the client ID queue.
+	Ack(ctx context.Context, clientID string, id string) error
+}
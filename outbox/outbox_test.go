@@ -0,0 +1,68 @@
+package outbox
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStore(t *testing.T) {
+	fileStore, err := NewFileStore(t.TempDir())
+	require.NoError(t, err)
+
+	tests := []struct {
+		name  string
+		store Store
+	}{
+		{
+			name:  "memory store",
+			store: NewMemoryStore(),
+		},
+		{
+			name:  "file store",
+			store: fileStore,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			store := tc.store
+
+			entries, err := store.List(ctx, "4711")
+			require.NoError(t, err)
+			require.Empty(t, entries)
+
+			require.NoError(t, store.Append(ctx, "4711", Entry{ID: "1", Data: []byte("one")}))
+			require.NoError(t, store.Append(ctx, "4711", Entry{ID: "2", Data: []byte("two"), Expires: time.Now().Add(-time.Second)}))
+			require.NoError(t, store.Append(ctx, "4711", Entry{ID: "3", Data: []byte("three"), Expires: time.Now().Add(time.Minute)}))
+			require.NoError(t, store.Append(ctx, "4711", Entry{ID: "4", Data: []byte("four")}))
+			require.NoError(t, store.Append(ctx, "4712", Entry{ID: "5", Data: []byte("five")}))
+
+			entries, err = store.List(ctx, "4711")
+			require.NoError(t, err)
+			require.Equal(t, []string{"1", "3", "4"}, entryIDs(entries))
+			require.Equal(t, []byte("three"), entries[1].Data)
+
+			require.NoError(t, store.Ack(ctx, "4711", "3"))
+			require.NoError(t, store.Ack(ctx, "4711", "5"))
+			require.NoError(t, store.Ack(ctx, "4713", "1"))
+
+			entries, err = store.List(ctx, "4711")
+			require.NoError(t, err)
+			require.Equal(t, []string{"1", "4"}, entryIDs(entries))
+
+			entries, err = store.List(ctx, "4712")
+			require.NoError(t, err)
+			require.Equal(t, []string{"5"}, entryIDs(entries))
+		})
+	}
+}
+
+func entryIDs(entries []Entry) (result []string) {
+	for _, entry := range entries {
+		result = append(result, entry.ID)
+	}
+	return result
+}
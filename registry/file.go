@@ -0,0 +1,172 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/grepplabs/backstream/internal/util"
+)
+
+const (
+	filePollInterval = 1 * time.Second
+	fileRegistryPerm = 0o755
+)
+
+// fileRegistry stores every ownership record as an empty file <dir>/<client-id>/<owner>,
+// so that several processes on the same host can share it without extra locking.
+// The lease expiry is stored as the file modification time.
+type fileRegistry struct {
+	dir string
+}
+
+func NewFileRegistry(dir string) (Registry, error) {
+	if err := os.MkdirAll(dir, fileRegistryPerm); err != nil {
+		return nil, err
+	}
+	return &fileRegistry{
+		dir: dir,
+	}, nil
+}
+
+func (r *fileRegistry) Register(_ context.Context, clientID string, owner string, ttl time.Duration) error {
+	clientDir := r.clientDir(clientID)
+	if err := os.MkdirAll(clientDir, fileRegistryPerm); err != nil {
+		return err
+	}
+	name := filepath.Join(clientDir, url.PathEscape(owner))
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	expiry := time.Now().Add(ttl)
+	return os.Chtimes(name, expiry, expiry)
+}
+
+func (r *fileRegistry) Unregister(_ context.Context, clientID string, owner string) error {
+	err := os.Remove(filepath.Join(r.clientDir(clientID), url.PathEscape(owner)))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (r *fileRegistry) Lookup(_ context.Context, clientID string) (result []string, err error) {
+	entries, err := os.ReadDir(r.clientDir(clientID))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	now := time.Now()
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return nil, err
+		}
+		if !now.Before(info.ModTime()) {
+			continue
+		}
+		owner, err := url.PathUnescape(entry.Name())
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, owner)
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+// Watch polls the registry directory and reports the difference between snapshots.
+func (r *fileRegistry) Watch(ctx context.Context, clientID string) (<-chan Event, error) {
+	previous, err := r.snapshot(clientID)
+	if err != nil {
+		return nil, err
+	}
+	ch := make(chan Event, watchBufferSize)
+	go func() {
+		defer close(ch)
+		ticker := time.NewTicker(filePollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				current, err := r.snapshot(clientID)
+				if err != nil {
+					continue
+				}
+				for event := range current {
+					if _, ok := previous[event]; !ok && !sendEvent(ctx, ch, event) {
+						return
+					}
+				}
+				for event := range previous {
+					if _, ok := current[event]; !ok {
+						event.Type = EventUnregister
+						if !sendEvent(ctx, ch, event) {
+							return
+						}
+					}
+				}
+				previous = current
+			}
+		}
+	}()
+	return ch, nil
+}
+
+func (r *fileRegistry) snapshot(clientID string) (map[Event]struct{}, error) {
+	var clientDirs []string
+	if clientID != "" {
+		clientDirs = []string{r.clientDir(clientID)}
+	} else {
+		entries, err := os.ReadDir(r.dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if _, ok := util.ClientIDFromDir(entry.Name()); entry.IsDir() && ok {
+				clientDirs = append(clientDirs, filepath.Join(r.dir, entry.Name()))
+			}
+		}
+	}
+	result := make(map[Event]struct{})
+	for _, clientDir := range clientDirs {
+		id, ok := util.ClientIDFromDir(filepath.Base(clientDir))
+		if !ok {
+			continue
+		}
+		entries, err := os.ReadDir(clientDir)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return nil, err
+		}
+		for _, entry := range entries {
+			owner, err := url.PathUnescape(entry.Name())
+			if err != nil {
+				continue
+			}
+			result[Event{Type: EventRegister, ClientID: id, Owner: owner}] = struct{}{}
+		}
+	}
+	return result, nil
+}
+
+func (r *fileRegistry) clientDir(clientID string) string {
+	return util.ClientDir(r.dir, clientID)
+}
@@ -0,0 +1,114 @@
+package registry
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+const watchBufferSize = 128
+
+type memoryRegistry struct {
+	mu       sync.Mutex
+	owners   map[string]map[string]time.Time
+	watchers map[*watcher]struct{}
+}
+
+func NewMemoryRegistry() Registry {
+	return &memoryRegistry{
+		owners:   make(map[string]map[string]time.Time),
+		watchers: make(map[*watcher]struct{}),
+	}
+}
+
+func (r *memoryRegistry) Register(_ context.Context, clientID string, owner string, ttl time.Duration) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	owners, ok := r.owners[clientID]
+	if !ok {
+		owners = make(map[string]time.Time)
+		r.owners[clientID] = owners
+	}
+	expiry, ok := owners[owner]
+	owners[owner] = time.Now().Add(ttl)
+	if !ok || time.Now().After(expiry) {
+		r.notifyLocked(Event{Type: EventRegister, ClientID: clientID, Owner: owner})
+	}
+	return nil
+}
+
+func (r *memoryRegistry) Unregister(_ context.Context, clientID string, owner string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	owners, ok := r.owners[clientID]
+	if !ok {
+		return nil
+	}
+	if _, ok = owners[owner]; !ok {
+		return nil
+	}
+	delete(owners, owner)
+	if len(owners) == 0 {
+		delete(r.owners, clientID)
+	}
+	r.notifyLocked(Event{Type: EventUnregister, ClientID: clientID, Owner: owner})
+	return nil
+}
+
+func (r *memoryRegistry) Lookup(_ context.Context, clientID string) (result []string, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	for owner, expiry := range r.owners[clientID] {
+		if now.Before(expiry) {
+			result = append(result, owner)
+		}
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+func (r *memoryRegistry) Watch(ctx context.Context, clientID string) (<-chan Event, error) {
+	w := &watcher{
+		clientID: clientID,
+		ch:       make(chan Event, watchBufferSize),
+	}
+	r.mu.Lock()
+	r.watchers[w] = struct{}{}
+	r.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		r.mu.Lock()
+		delete(r.watchers, w)
+		close(w.ch)
+		r.mu.Unlock()
+	}()
+	return w.ch, nil
+}
+
+func (r *memoryRegistry) notifyLocked(event Event) {
+	for w := range r.watchers {
+		w.notify(event)
+	}
+}
+
+type watcher struct {
+	clientID string
+	ch       chan Event
+}
+
+func (w *watcher) notify(event Event) {
+	if w.clientID != "" && w.clientID != event.ClientID {
+		return
+	}
+	// slow watchers must not block the registry
+	select {
+	case w.ch <- event:
+	default:
+	}
+}
@@ -0,0 +1,149 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const defaultRedisKeyPrefix = "backstream:registry:"
+
+// registerScript drops expired leases, adds / refreshes the lease and extends the key expiry to the latest lease.
+var registerScript = redis.NewScript(`
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', ARGV[1])
+local added = redis.call('ZADD', KEYS[1], ARGV[2], ARGV[3])
+local last = redis.call('ZRANGE', KEYS[1], -1, -1, 'WITHSCORES')
+redis.call('PEXPIREAT', KEYS[1], last[2])
+return added
+`)
+
+// redisRegistry stores leases of a client ID in a sorted set with the lease expiry (unix millis) as a score.
+// Register and unregister events are published to the events channel.
+type redisRegistry struct {
+	client redis.UniversalClient
+	prefix string
+}
+
+type RedisOption func(*redisRegistry)
+
+func WithRedisKeyPrefix(prefix string) RedisOption {
+	return func(r *redisRegistry) {
+		r.prefix = prefix
+	}
+}
+
+type redisEvent struct {
+	Type     EventType `json:"type"`
+	ClientID string    `json:"clientID"`
+	Owner    string    `json:"owner"`
+}
+
+func NewRedisRegistry(client redis.UniversalClient, opts ...RedisOption) Registry {
+	r := &redisRegistry{
+		client: client,
+		prefix: defaultRedisKeyPrefix,
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+func (r *redisRegistry) Register(ctx context.Context, clientID string, owner string, ttl time.Duration) error {
+	now := time.Now()
+	added, err := registerScript.Run(ctx, r.client, []string{r.clientKey(clientID)},
+		strconv.FormatInt(now.UnixMilli(), 10),
+		strconv.FormatInt(now.Add(ttl).UnixMilli(), 10),
+		owner,
+	).Int()
+	if err != nil {
+		return err
+	}
+	if added == 0 {
+		return nil
+	}
+	return r.publish(ctx, Event{Type: EventRegister, ClientID: clientID, Owner: owner})
+}
+
+func (r *redisRegistry) Unregister(ctx context.Context, clientID string, owner string) error {
+	removed, err := r.client.ZRem(ctx, r.clientKey(clientID), owner).Result()
+	if err != nil {
+		return err
+	}
+	if removed == 0 {
+		return nil
+	}
+	return r.publish(ctx, Event{Type: EventUnregister, ClientID: clientID, Owner: owner})
+}
+
+func (r *redisRegistry) Lookup(ctx context.Context, clientID string) ([]string, error) {
+	result, err := r.client.ZRangeByScore(ctx, r.clientKey(clientID), &redis.ZRangeBy{
+		Min: "(" + strconv.FormatInt(time.Now().UnixMilli(), 10),
+		Max: "+inf",
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(result) == 0 {
+		return nil, nil
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+func (r *redisRegistry) Watch(ctx context.Context, clientID string) (<-chan Event, error) {
+	sub := r.client.Subscribe(ctx, r.eventsChannel())
+	// wait for the subscription confirmation, so that no events published afterward are missed
+	if _, err := sub.Receive(ctx); err != nil {
+		_ = sub.Close()
+		return nil, err
+	}
+	ch := make(chan Event, watchBufferSize)
+	go func() {
+		defer close(ch)
+		defer sub.Close()
+
+		messages := sub.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg, ok := <-messages:
+				if !ok {
+					return
+				}
+				var e redisEvent
+				if err := json.Unmarshal([]byte(msg.Payload), &e); err != nil {
+					continue
+				}
+				if clientID != "" && clientID != e.ClientID {
+					continue
+				}
+				if !sendEvent(ctx, ch, Event{Type: e.Type, ClientID: e.ClientID, Owner: e.Owner}) {
+					return
+				}
+			}
+		}
+	}()
+	return ch, nil
+}
+
+func (r *redisRegistry) publish(ctx context.Context, event Event) error {
+	payload, err := json.Marshal(redisEvent{Type: event.Type, ClientID: event.ClientID, Owner: event.Owner})
+	if err != nil {
+		return err
+	}
+	return r.client.Publish(ctx, r.eventsChannel(), payload).Err()
+}
+
+func (r *redisRegistry) clientKey(clientID string) string {
+	return r.prefix + "client:" + clientID
+}
+
+func (r *redisRegistry) eventsChannel() string {
+	return r.prefix + "events"
+}
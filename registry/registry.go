@@ -0,0 +1,53 @@
+package registry
+
+import (
+	"context"
+	"time"
+)
+
+type EventType int
+
+const (
+	EventRegister EventType = iota
+	EventUnregister
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventRegister:
+		return "register"
+	case EventUnregister:
+		return "unregister"
+	default:
+		return "unknown"
+	}
+}
+
+type Event struct {
+	Type     EventType
+	ClientID string
+	Owner    string
+}
+
+// Registry keeps track of which proxy replica owns connections for a client ID.
+type Registry interface {
+	// Register records that owner holds at least one connection for clientID.
+	// The record is a lease which expires after ttl unless it is registered again.
+	Register(ctx context.Context, clientID string, owner string, ttl time.Duration) error
+	// Unregister removes the ownership record of owner for clientID.
+	Unregister(ctx context.Context, clientID string, owner string) error
+	// Lookup returns all owners holding unexpired leases for clientID.
+	Lookup(ctx context.Context, clientID string) ([]string, error)
+	// Watch reports register and unregister events for clientID or for all client IDs if clientID is empty.
+	// Lease expiration is not guaranteed to be reported. The channel is closed when the ctx is done.
+	Watch(ctx context.Context, clientID string) (<-chan Event, error)
+}
+
+func sendEvent(ctx context.Context, ch chan<- Event, event Event) bool {
+	select {
+	case ch <- event:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,98 @@
+package registry
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistry(t *testing.T) {
+	fileRegistry, err := NewFileRegistry(t.TempDir())
+	require.NoError(t, err)
+
+	redisServer := miniredis.RunT(t)
+	redisClient := redis.NewClient(&redis.Options{Addr: redisServer.Addr()})
+	defer redisClient.Close()
+
+	tests := []struct {
+		name     string
+		registry Registry
+	}{
+		{
+			name:     "memory registry",
+			registry: NewMemoryRegistry(),
+		},
+		{
+			name:     "file registry",
+			registry: fileRegistry,
+		},
+		{
+			name:     "redis registry",
+			registry: NewRedisRegistry(redisClient),
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			reg := tc.registry
+
+			events, err := reg.Watch(ctx, "4711")
+			require.NoError(t, err)
+
+			owners, err := reg.Lookup(ctx, "4711")
+			require.NoError(t, err)
+			require.Empty(t, owners)
+
+			require.NoError(t, reg.Register(ctx, "4711", "http://10.0.0.2:8080", time.Minute))
+			require.NoError(t, reg.Register(ctx, "4711", "http://10.0.0.1:8080", time.Minute))
+			require.NoError(t, reg.Register(ctx, "4711", "http://10.0.0.1:8080", time.Minute))
+			require.NoError(t, reg.Register(ctx, "../4712", "http://10.0.0.1:8080", time.Minute))
+			require.NoError(t, reg.Register(ctx, "4711", "http://10.0.0.3:8080", -time.Second))
+
+			owners, err = reg.Lookup(ctx, "4711")
+			require.NoError(t, err)
+			require.Equal(t, []string{"http://10.0.0.1:8080", "http://10.0.0.2:8080"}, owners)
+
+			require.NoError(t, reg.Unregister(ctx, "4711", "http://10.0.0.1:8080"))
+			require.NoError(t, reg.Unregister(ctx, "4711", "http://10.0.0.4:8080"))
+			require.NoError(t, reg.Unregister(ctx, "4713", "http://10.0.0.4:8080"))
+
+			owners, err = reg.Lookup(ctx, "4711")
+			require.NoError(t, err)
+			require.Equal(t, []string{"http://10.0.0.2:8080"}, owners)
+
+			owners, err = reg.Lookup(ctx, "../4712")
+			require.NoError(t, err)
+			require.Equal(t, []string{"http://10.0.0.1:8080"}, owners)
+
+			waitEvent(t, events, Event{Type: EventRegister, ClientID: "4711", Owner: "http://10.0.0.2:8080"})
+
+			require.NoError(t, reg.Unregister(ctx, "4711", "http://10.0.0.2:8080"))
+			waitEvent(t, events, Event{Type: EventUnregister, ClientID: "4711", Owner: "http://10.0.0.2:8080"})
+
+			owners, err = reg.Lookup(ctx, "4711")
+			require.NoError(t, err)
+			require.Empty(t, owners)
+		})
+	}
+}
+
+func waitEvent(t *testing.T, events <-chan Event, expected Event) {
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case event := <-events:
+			require.Equal(t, expected.ClientID, event.ClientID)
+			if event == expected {
+				return
+			}
+		case <-timeout:
+			require.Fail(t, "event not received", "%v", expected)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package ws
+
+import (
+	"math/rand"
+	"sync/atomic"
+)
+
+// Balancer chooses the connection for a proxy request from the matching connections.
+type Balancer interface {
+	Pick(conns []*Conn) *Conn
+}
+
+type randomBalancer struct{}
+
+func NewRandomBalancer() Balancer {
+	return randomBalancer{}
+}
+
+func (randomBalancer) Pick(conns []*Conn) *Conn {
+	if len(conns) == 0 {
+		return nil
+	}
+	return conns[rand.Intn(len(conns))]
+}
+
+type roundRobinBalancer struct {
+	next atomic.Uint64
+}
+
+func NewRoundRobinBalancer() Balancer {
+	return &roundRobinBalancer{}
+}
+
+func (b *roundRobinBalancer) Pick(conns []*Conn) *Conn {
+	if len(conns) == 0 {
+		return nil
+	}
+	return conns[(b.next.Add(1)-1)%uint64(len(conns))]
+}
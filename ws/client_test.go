@@ -0,0 +1,78 @@
+package ws
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/grepplabs/backstream/internal/message"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientDialOptions(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	codec := NewProtoCodec[*message.Message]()
+	serve := NewServe(ctx, &noopProxyHandler{}, codec)
+	var subprotocols atomic.Value
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		subprotocols.Store(websocket.Subprotocols(r))
+		// API gateway
+		if r.Header.Get("X-Api-Key") != "secret" {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		serve.HandleWS(w, r)
+	}))
+	defer server.Close()
+	serverURL := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	_, err := NewClient(ctx, serverURL, &notifyRecorder{}, codec, WithClientID("4711")).GetConn()
+	require.ErrorContains(t, err, "status: 403")
+
+	var dials atomic.Int32
+	dialer := &websocket.Dialer{
+		NetDialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			dials.Add(1)
+			return (&net.Dialer{}).DialContext(ctx, network, addr)
+		},
+		HandshakeTimeout: time.Second,
+		Subprotocols:     []string{"gateway.v1"},
+	}
+	var upgraded atomic.Int32
+	_, err = NewClient(ctx, serverURL, &notifyRecorder{}, codec, WithClientID("4711"), WithClientDialer(dialer),
+		WithClientHeader(http.Header{"X-Api-Key": {"secret"}, HeaderClientId: {"spoofed"}}),
+		WithClientUpgradeHook(func(resp *http.Response) error {
+			require.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+			upgraded.Add(1)
+			return nil
+		})).GetConn()
+	require.NoError(t, err)
+	require.Equal(t, int32(1), dials.Load())
+	require.Equal(t, int32(1), upgraded.Load())
+	require.Equal(t, []string{"gateway.v1", SubprotocolProtoV1}, subprotocols.Load())
+	require.Equal(t, []string{"gateway.v1"}, dialer.Subprotocols)
+	require.NotNil(t, serve.GetConnByID("4711"))
+	require.Nil(t, serve.GetConnByID("spoofed"))
+
+	// the hook rejects the connection
+	_, err = NewClient(ctx, serverURL, &notifyRecorder{}, codec, WithClientID("4712"),
+		WithClientHeaderFunc(func() http.Header {
+			return http.Header{"X-Api-Key": {"secret"}}
+		}),
+		WithClientUpgradeHook(func(*http.Response) error {
+			return errors.New("unexpected gateway")
+		})).GetConn()
+	require.ErrorContains(t, err, "unexpected gateway")
+	require.Eventually(t, func() bool {
+		return serve.GetConnByID("4712") == nil
+	}, 3*time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,110 @@
+package ws
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grepplabs/backstream/internal/message"
+	"github.com/grepplabs/backstream/registry"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistryTTL(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	for _, ttl := range []time.Duration{0, 2, -time.Second} {
+		serve := NewServe(ctx, &noopProxyHandler{}, NewProtoCodec[*message.Message](),
+			WithServeCluster(registry.NewMemoryRegistry(), "http://10.0.0.1:8080"), WithServeRegistryTTL(ttl))
+		require.Equal(t, defaultRegistryTTL, serve.registryTTL)
+	}
+}
+
+func TestRefreshOwnership(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	reg := registry.NewMemoryRegistry()
+	codec := NewProtoCodec[*message.Message]()
+	serve := NewServe(ctx, &noopProxyHandler{}, codec,
+		WithServeCluster(reg, "http://10.0.0.1:8080"), WithServeRegistryTTL(150*time.Millisecond))
+	server := httptest.NewServer(http.HandlerFunc(serve.HandleWS))
+	defer server.Close()
+
+	clientCtx, clientCancel := context.WithCancel(ctx)
+	_, err := NewClient(clientCtx, "ws"+strings.TrimPrefix(server.URL, "http"), &notifyRecorder{}, codec, WithClientID("4711")).GetConn()
+	require.NoError(t, err)
+
+	lookup := func() []string {
+		owners, err := reg.Lookup(ctx, "4711")
+		require.NoError(t, err)
+		return owners
+	}
+	// the lease outlives the TTL while the agent is connected
+	time.Sleep(300 * time.Millisecond)
+	require.Equal(t, []string{"http://10.0.0.1:8080"}, lookup())
+
+	clientCancel()
+	require.Eventually(t, func() bool {
+		return serve.GetConnByID("4711") == nil && len(lookup()) == 0
+	}, 3*time.Second, 10*time.Millisecond)
+
+	// the refresh does not re-create the lease
+	serve.pool.withRegistered("4711", func() {
+		t.Fatal("client ID is not registered")
+	})
+	time.Sleep(200 * time.Millisecond)
+	require.Empty(t, lookup())
+}
+
+// blockingRegistry blocks the registration of the client ID until it is released.
+type blockingRegistry struct {
+	registry.Registry
+	clientID string
+	release  chan struct{}
+}
+
+func (r *blockingRegistry) Register(ctx context.Context, clientID string, owner string, ttl time.Duration) error {
+	if clientID == r.clientID {
+		select {
+		case <-r.release:
+		case <-ctx.Done():
+		}
+	}
+	return r.Registry.Register(ctx, clientID, owner, ttl)
+}
+
+func TestSlowRegistry(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	reg := &blockingRegistry{Registry: registry.NewMemoryRegistry(), clientID: "slow", release: make(chan struct{})}
+	codec := NewProtoCodec[*message.Message]()
+	serve := NewServe(ctx, &noopProxyHandler{}, codec, WithServeCluster(reg, "http://10.0.0.1:8080"))
+	server := httptest.NewServer(http.HandlerFunc(serve.HandleWS))
+	defer server.Close()
+	serverURL := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	slow := NewClient(ctx, serverURL, &notifyRecorder{}, codec, WithClientID("slow"))
+	slow.Start()
+	require.Eventually(t, func() bool {
+		return serve.GetConnByID("slow") != nil
+	}, 3*time.Second, 10*time.Millisecond)
+
+	// the registration of other client IDs is not blocked
+	_, err := NewClient(ctx, serverURL, &notifyRecorder{}, codec, WithClientID("4711")).GetConn()
+	require.NoError(t, err)
+	owners, err := reg.Lookup(ctx, "4711")
+	require.NoError(t, err)
+	require.Equal(t, []string{"http://10.0.0.1:8080"}, owners)
+
+	close(reg.release)
+	require.Eventually(t, func() bool {
+		owners, err := reg.Lookup(ctx, "slow")
+		return err == nil && len(owners) == 1
+	}, 3*time.Second, 10*time.Millisecond)
+}
@@ -12,6 +12,33 @@ type Codec[T proto.Message] interface {
 	IsBinary() bool
 }
 
+const (
+	CodecNameJson  = "json"
+	CodecNameProto = "proto"
+
+	subprotocolPrefix = "backstream.v1."
+	// SubprotocolJsonV1 is the WebSocket subprotocol of the JSON codec.
+	SubprotocolJsonV1 = subprotocolPrefix + CodecNameJson
+	// SubprotocolProtoV1 is the WebSocket subprotocol of the protobuf codec.
+	SubprotocolProtoV1 = subprotocolPrefix + CodecNameProto
+)
+
+// Subprotocol returns the WebSocket subprotocol of the codec or empty string if the codec is not named.
+func Subprotocol[T proto.Message](codec Codec[T]) string {
+	if name := codecName(codec); name != "" {
+		return subprotocolPrefix + name
+	}
+	return ""
+}
+
+// codecName returns the name of the codec or empty string if the codec is not named.
+func codecName[T proto.Message](codec Codec[T]) string {
+	if named, ok := codec.(interface{ Name() string }); ok {
+		return named.Name()
+	}
+	return ""
+}
+
 type JsonCodec[T proto.Message] struct {
 }
 
@@ -30,6 +57,10 @@ func (c JsonCodec[T]) IsBinary() bool {
 	return false
 }
 
+func (c JsonCodec[T]) Name() string {
+	return CodecNameJson
+}
+
 type ProtoCodec[T proto.Message] struct {
 }
 
@@ -48,3 +79,7 @@ func (c ProtoCodec[T]) Decode(data []byte, t T) error {
 func (c ProtoCodec[T]) IsBinary() bool {
 	return true
 }
+
+func (c ProtoCodec[T]) Name() string {
+	return CodecNameProto
+}
@@ -0,0 +1,56 @@
+package ws
+
+import (
+	"reflect"
+
+	"github.com/fxamacker/cbor/v2"
+	"google.golang.org/protobuf/proto"
+)
+
+const CodecNameCbor = "cbor"
+
+// SubprotocolCborV1 is the WebSocket subprotocol of the CBOR codec.
+const SubprotocolCborV1 = subprotocolPrefix + CodecNameCbor
+
+var cborDecMode = func() cbor.DecMode {
+	mode, err := cbor.DecOptions{
+		DefaultMapType: reflect.TypeOf(map[string]any(nil)),
+	}.DecMode()
+	if err != nil {
+		panic(err)
+	}
+	return mode
+}()
+
+// CborCodec encodes proto messages as CBOR maps keyed by the JSON field names.
+type CborCodec[T proto.Message] struct {
+}
+
+func NewCborCodec[T proto.Message]() Codec[T] {
+	return &CborCodec[T]{}
+}
+
+func (c CborCodec[T]) Encode(v T) ([]byte, error) {
+	m, err := protoToMap(v.ProtoReflect())
+	if err != nil {
+		return nil, err
+	}
+	return cbor.Marshal(m)
+}
+
+func (c CborCodec[T]) Decode(data []byte, t T) error {
+	var raw map[string]any
+	if err := cborDecMode.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	proto.Reset(t)
+	return mapToProto(raw, t.ProtoReflect())
+}
+
+func (c CborCodec[T]) IsBinary() bool {
+	return true
+}
+
+func (c CborCodec[T]) Name() string {
+	return CodecNameCbor
+}
@@ -0,0 +1,168 @@
+package ws
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/proto"
+)
+
+const CodecNameMsgpack = "msgpack"
+
+// msgpackMaxDepth limits nesting of arrays and maps, which are decoded recursively.
+const msgpackMaxDepth = 64
+
+// SubprotocolMsgpackV1 is the WebSocket subprotocol of the MessagePack codec.
+const SubprotocolMsgpackV1 = subprotocolPrefix + CodecNameMsgpack
+
+// MsgpackCodec encodes proto messages as MessagePack maps keyed by the JSON field names.
+type MsgpackCodec[T proto.Message] struct {
+}
+
+func NewMsgpackCodec[T proto.Message]() Codec[T] {
+	return &MsgpackCodec[T]{}
+}
+
+func (c MsgpackCodec[T]) Encode(v T) ([]byte, error) {
+	m, err := protoToMap(v.ProtoReflect())
+	if err != nil {
+		return nil, err
+	}
+	return msgpack.Marshal(m)
+}
+
+func (c MsgpackCodec[T]) Decode(data []byte, t T) error {
+	if err := validateMsgpack(data); err != nil {
+		return err
+	}
+	var raw map[string]any
+	if err := msgpack.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	proto.Reset(t)
+	return mapToProto(raw, t.ProtoReflect())
+}
+
+func (c MsgpackCodec[T]) IsBinary() bool {
+	return true
+}
+
+func (c MsgpackCodec[T]) Name() string {
+	return CodecNameMsgpack
+}
+
+// validateMsgpack checks the structure before decoding into interfaces, as msgpack preallocates
+// arrays and maps of the declared length, which must not exceed the length of the data.
+func validateMsgpack(data []byte) error {
+	errTruncated := errors.New("msgpack: truncated data")
+	pos := 0
+	// number of items still expected at each nesting level
+	pending := []int{1}
+	for len(pending) != 0 {
+		if pending[len(pending)-1] == 0 {
+			pending = pending[:len(pending)-1]
+			continue
+		}
+		pending[len(pending)-1]--
+
+		if pos >= len(data) {
+			return errTruncated
+		}
+		c := data[pos]
+		pos++
+
+		// uintN reads a big endian length of n bytes
+		uintN := func(n int) (int, error) {
+			if len(data)-pos < n {
+				return 0, errTruncated
+			}
+			var v uint64
+			switch n {
+			case 1:
+				v = uint64(data[pos])
+			case 2:
+				v = uint64(binary.BigEndian.Uint16(data[pos:]))
+			case 4:
+				v = uint64(binary.BigEndian.Uint32(data[pos:]))
+			}
+			pos += n
+			if v > uint64(len(data)) {
+				return 0, errTruncated
+			}
+			return int(v), nil
+		}
+		skip := 0
+		items := -1
+		var err error
+		switch {
+		case c <= 0x7f || c >= 0xe0 || c == 0xc0 || c == 0xc2 || c == 0xc3:
+		case c >= 0x80 && c <= 0x8f:
+			items = 2 * int(c&0x0f)
+		case c >= 0x90 && c <= 0x9f:
+			items = int(c & 0x0f)
+		case c >= 0xa0 && c <= 0xbf:
+			skip = int(c & 0x1f)
+		case c == 0xc4 || c == 0xd9:
+			skip, err = uintN(1)
+		case c == 0xc5 || c == 0xda:
+			skip, err = uintN(2)
+		case c == 0xc6 || c == 0xdb:
+			skip, err = uintN(4)
+		case c == 0xc7:
+			skip, err = uintN(1)
+			skip++
+		case c == 0xc8:
+			skip, err = uintN(2)
+			skip++
+		case c == 0xc9:
+			skip, err = uintN(4)
+			skip++
+		case c == 0xca:
+			skip = 4
+		case c == 0xcb:
+			skip = 8
+		case c == 0xcc || c == 0xd0:
+			skip = 1
+		case c == 0xcd || c == 0xd1:
+			skip = 2
+		case c == 0xce || c == 0xd2:
+			skip = 4
+		case c == 0xcf || c == 0xd3:
+			skip = 8
+		case c >= 0xd4 && c <= 0xd8:
+			skip = 1 + 1<<(c-0xd4)
+		case c == 0xdc:
+			items, err = uintN(2)
+		case c == 0xdd:
+			items, err = uintN(4)
+		case c == 0xde:
+			items, err = uintN(2)
+			items *= 2
+		case c == 0xdf:
+			items, err = uintN(4)
+			items *= 2
+		default:
+			return fmt.Errorf("msgpack: invalid code %x", c)
+		}
+		if err != nil {
+			return err
+		}
+		if len(data)-pos < skip {
+			return errTruncated
+		}
+		pos += skip
+		if items > 0 {
+			// every item takes at least one byte
+			if items > len(data)-pos {
+				return errTruncated
+			}
+			if len(pending) > msgpackMaxDepth {
+				return errors.New("msgpack: max nesting depth exceeded")
+			}
+			pending = append(pending, items)
+		}
+	}
+	return nil
+}
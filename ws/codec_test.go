@@ -1,11 +1,14 @@
 package ws
 
 import (
+	"bytes"
 	"testing"
 
 	"github.com/google/uuid"
 	"github.com/grepplabs/backstream/internal/message"
 	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
 )
 
 func TestCodec(t *testing.T) {
@@ -72,6 +75,66 @@ func TestCodec(t *testing.T) {
 			codec:  NewProtoCodec[*message.Message](),
 			binary: true,
 		},
+		{
+			name: "Msgpack Notify",
+			msg: &message.Message{
+				Id:   uuid.New().String(),
+				Type: message.Message_NOTIFY,
+				Data: nil,
+			},
+			codec:  NewMsgpackCodec[*message.Message](),
+			binary: true,
+		},
+		{
+			name: "Cbor Notify",
+			msg: &message.Message{
+				Id:   uuid.New().String(),
+				Type: message.Message_NOTIFY,
+				Data: nil,
+			},
+			codec:  NewCborCodec[*message.Message](),
+			binary: true,
+		},
+		{
+			name: "Msgpack Request",
+			msg: &message.Message{
+				Id:   uuid.New().String(),
+				Type: message.Message_REQUEST,
+				Data: []byte{42},
+			},
+			codec:  NewMsgpackCodec[*message.Message](),
+			binary: true,
+		},
+		{
+			name: "Cbor Request",
+			msg: &message.Message{
+				Id:   uuid.New().String(),
+				Type: message.Message_REQUEST,
+				Data: []byte{42},
+			},
+			codec:  NewCborCodec[*message.Message](),
+			binary: true,
+		},
+		{
+			name: "Msgpack Response",
+			msg: &message.Message{
+				Id:   uuid.New().String(),
+				Type: message.Message_RESPONSE,
+				Data: []byte{42},
+			},
+			codec:  NewMsgpackCodec[*message.Message](),
+			binary: true,
+		},
+		{
+			name: "Cbor Response",
+			msg: &message.Message{
+				Id:   uuid.New().String(),
+				Type: message.Message_RESPONSE,
+				Data: []byte{42},
+			},
+			codec:  NewCborCodec[*message.Message](),
+			binary: true,
+		},
 	}
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
@@ -87,3 +150,191 @@ func TestCodec(t *testing.T) {
 		})
 	}
 }
+
+func TestCodecHttpEvents(t *testing.T) {
+	headers := map[string]*structpb.ListValue{
+		"Content-Type": {Values: []*structpb.Value{structpb.NewStringValue("text/plain")}},
+		"X-Multi":      {Values: []*structpb.Value{structpb.NewStringValue("a"), structpb.NewStringValue("b")}},
+	}
+	request := &message.EventHTTPRequest{
+		Method:   "POST",
+		RawPath:  "/test",
+		RawQuery: "a=1",
+		Headers:  headers,
+		Body:     []byte("body"),
+	}
+	response := &message.EventHTTPResponse{
+		StatusCode: 201,
+		Headers:    headers,
+		Body:       []byte{0, 1, 2},
+	}
+	hello := &message.Hello{
+		Version:        1,
+		Codecs:         []string{CodecNameMsgpack, CodecNameCbor},
+		MaxMessageSize: 1 << 40,
+		Features:       []string{FeatureCompression},
+	}
+	tests := []struct {
+		name     string
+		request  Codec[*message.EventHTTPRequest]
+		response Codec[*message.EventHTTPResponse]
+		hello    Codec[*message.Hello]
+	}{
+		{
+			name:     "Json",
+			request:  NewJsonCodec[*message.EventHTTPRequest](),
+			response: NewJsonCodec[*message.EventHTTPResponse](),
+			hello:    NewJsonCodec[*message.Hello](),
+		},
+		{
+			name:     "Proto",
+			request:  NewProtoCodec[*message.EventHTTPRequest](),
+			response: NewProtoCodec[*message.EventHTTPResponse](),
+			hello:    NewProtoCodec[*message.Hello](),
+		},
+		{
+			name:     "Msgpack",
+			request:  NewMsgpackCodec[*message.EventHTTPRequest](),
+			response: NewMsgpackCodec[*message.EventHTTPResponse](),
+			hello:    NewMsgpackCodec[*message.Hello](),
+		},
+		{
+			name:     "Cbor",
+			request:  NewCborCodec[*message.EventHTTPRequest](),
+			response: NewCborCodec[*message.EventHTTPResponse](),
+			hello:    NewCborCodec[*message.Hello](),
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			data, err := tc.request.Encode(request)
+			require.NoError(t, err)
+			var decodedRequest message.EventHTTPRequest
+			require.NoError(t, tc.request.Decode(data, &decodedRequest))
+			require.True(t, proto.Equal(request, &decodedRequest), "%v != %v", request, &decodedRequest)
+
+			data, err = tc.response.Encode(response)
+			require.NoError(t, err)
+			var decodedResponse message.EventHTTPResponse
+			require.NoError(t, tc.response.Decode(data, &decodedResponse))
+			require.True(t, proto.Equal(response, &decodedResponse), "%v != %v", response, &decodedResponse)
+
+			data, err = tc.hello.Encode(hello)
+			require.NoError(t, err)
+			var decodedHello message.Hello
+			require.NoError(t, tc.hello.Decode(data, &decodedHello))
+			require.True(t, proto.Equal(hello, &decodedHello), "%v != %v", hello, &decodedHello)
+		})
+	}
+}
+
+func FuzzCodec(f *testing.F) {
+	f.Add("id", int32(1), []byte{42}, true)
+	f.Add("", int32(0), []byte(nil), false)
+	f.Add("\x00\xff", int32(-1), []byte{0, 0xff}, true)
+
+	codecs := []Codec[*message.Message]{
+		NewJsonCodec[*message.Message](),
+		NewProtoCodec[*message.Message](),
+		NewMsgpackCodec[*message.Message](),
+		NewCborCodec[*message.Message](),
+	}
+	f.Fuzz(func(t *testing.T, id string, msgType int32, data []byte, ack bool) {
+		msg := &message.Message{
+			Id:   id,
+			Type: message.Message_Type(msgType),
+			Data: data,
+			Ack:  ack,
+		}
+		for _, from := range codecs {
+			encoded, err := from.Encode(msg)
+			if err != nil {
+				// e.g. JSON does not accept invalid UTF-8 strings
+				continue
+			}
+			var decoded message.Message
+			require.NoError(t, from.Decode(encoded, &decoded))
+			require.True(t, proto.Equal(msg, &decoded), "%s: %v != %v", codecName(from), msg, &decoded)
+
+			// transcode the decoded message with every other codec
+			for _, to := range codecs {
+				reencoded, err := to.Encode(&decoded)
+				if err != nil {
+					continue
+				}
+				var transcoded message.Message
+				require.NoError(t, to.Decode(reencoded, &transcoded))
+				require.True(t, proto.Equal(msg, &transcoded), "%s -> %s: %v != %v", codecName(from), codecName(to), msg, &transcoded)
+			}
+		}
+	})
+}
+
+func FuzzCodecDecode(f *testing.F) {
+	codecs := []Codec[*message.EventHTTPRequest]{
+		NewJsonCodec[*message.EventHTTPRequest](),
+		NewProtoCodec[*message.EventHTTPRequest](),
+		NewMsgpackCodec[*message.EventHTTPRequest](),
+		NewCborCodec[*message.EventHTTPRequest](),
+	}
+	seed := &message.EventHTTPRequest{
+		Method:  "GET",
+		RawPath: "/test",
+		Headers: map[string]*structpb.ListValue{
+			"X-Test": {Values: []*structpb.Value{structpb.NewStringValue("a"), structpb.NewNumberValue(1)}},
+		},
+		Body: []byte("body"),
+	}
+	for _, codec := range codecs {
+		data, err := codec.Encode(seed)
+		require.NoError(f, err)
+		f.Add(data)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		// arbitrary input must be rejected or decoded, but never panic
+		for _, codec := range codecs {
+			var event message.EventHTTPRequest
+			_ = codec.Decode(data, &event)
+		}
+	})
+}
+
+func TestCodecDecodeLimits(t *testing.T) {
+	tests := []struct {
+		name  string
+		data  []byte
+		codec Codec[*message.EventHTTPRequest]
+	}{
+		{
+			name:  "Msgpack array length",
+			data:  []byte{0x81, 0xa4, 'b', 'o', 'd', 'y', 0xdd, 0xff, 0xff, 0xff, 0xff},
+			codec: NewMsgpackCodec[*message.EventHTTPRequest](),
+		},
+		{
+			name:  "Msgpack map length",
+			data:  []byte{0xdf, 0xff, 0xff, 0xff, 0xff},
+			codec: NewMsgpackCodec[*message.EventHTTPRequest](),
+		},
+		{
+			name:  "Msgpack nesting",
+			data:  append([]byte{0x81, 0xa7, 'h', 'e', 'a', 'd', 'e', 'r', 's'}, bytes.Repeat([]byte{0x91}, 1000)...),
+			codec: NewMsgpackCodec[*message.EventHTTPRequest](),
+		},
+		{
+			name:  "Cbor array length",
+			data:  []byte{0xa1, 0x64, 'b', 'o', 'd', 'y', 0x9b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+			codec: NewCborCodec[*message.EventHTTPRequest](),
+		},
+		{
+			name:  "Cbor nesting",
+			data:  append([]byte{0xa1, 0x67, 'h', 'e', 'a', 'd', 'e', 'r', 's'}, bytes.Repeat([]byte{0x81}, 1000)...),
+			codec: NewCborCodec[*message.EventHTTPRequest](),
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var event message.EventHTTPRequest
+			require.Error(t, tc.codec.Decode(tc.data, &event))
+		})
+	}
+}
@@ -0,0 +1,217 @@
+package ws
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/grepplabs/backstream/internal/message"
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	EncodingGzip = "gzip"
+	EncodingZstd = "zstd"
+)
+
+// Compressor compresses the Message data, the Encoding is stored in the message envelope.
+type Compressor interface {
+	Encoding() string
+	Compress(data []byte) ([]byte, error)
+	// Decompress fails with ErrMessageTooLarge if the decompressed data exceed maxSize bytes.
+	Decompress(data []byte, maxSize int64) ([]byte, error)
+}
+
+var builtinCompressors = map[string]Compressor{
+	EncodingGzip: NewGzipCompressor(gzip.DefaultCompression),
+	EncodingZstd: NewZstdCompressor(zstd.SpeedDefault),
+}
+
+type gzipCompressor struct {
+	level int
+}
+
+func NewGzipCompressor(level int) Compressor {
+	return &gzipCompressor{
+		level: level,
+	}
+}
+
+func (c *gzipCompressor) Encoding() string {
+	return EncodingGzip
+}
+
+func (c *gzipCompressor) Compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := gzip.NewWriterLevel(&buf, c.level)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = w.Write(data); err != nil {
+		return nil, err
+	}
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (c *gzipCompressor) Decompress(data []byte, maxSize int64) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	output, err := io.ReadAll(io.LimitReader(r, maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(output)) > maxSize {
+		return nil, fmt.Errorf("%w: decompressed data exceed %d bytes", ErrMessageTooLarge, maxSize)
+	}
+	return output, nil
+}
+
+type zstdCompressor struct {
+	level zstd.EncoderLevel
+
+	once    sync.Once
+	encoder *zstd.Encoder
+	err     error
+}
+
+func NewZstdCompressor(level zstd.EncoderLevel) Compressor {
+	return &zstdCompressor{
+		level: level,
+	}
+}
+
+func (c *zstdCompressor) Encoding() string {
+	return EncodingZstd
+}
+
+func (c *zstdCompressor) Compress(data []byte) ([]byte, error) {
+	c.once.Do(func() {
+		c.encoder, c.err = zstd.NewWriter(nil, zstd.WithEncoderLevel(c.level))
+	})
+	if c.err != nil {
+		return nil, c.err
+	}
+	return c.encoder.EncodeAll(data, nil), nil
+}
+
+func (c *zstdCompressor) Decompress(data []byte, maxSize int64) ([]byte, error) {
+	decoder, err := zstd.NewReader(bytes.NewReader(data), zstd.WithDecoderConcurrency(1), zstd.WithDecoderLowmem(true))
+	if err != nil {
+		return nil, err
+	}
+	defer decoder.Close()
+	output, err := io.ReadAll(io.LimitReader(decoder, maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(output)) > maxSize {
+		return nil, fmt.Errorf("%w: decompressed data exceed %d bytes", ErrMessageTooLarge, maxSize)
+	}
+	return output, nil
+}
+
+// compressMessage compresses the message data if it is at least threshold bytes long.
+// The returned function restores the original message.
+func compressMessage(msg *message.Message, compressor Compressor, threshold int) (func(), error) {
+	if msg.Encoding != "" || len(msg.Data) < threshold || len(msg.Data) == 0 {
+		return func() {}, nil
+	}
+	compressed, err := compressor.Compress(msg.Data)
+	if err != nil {
+		return nil, err
+	}
+	data := msg.Data
+	msg.Data = compressed
+	msg.Encoding = compressor.Encoding()
+	return func() {
+		msg.Data = data
+		msg.Encoding = ""
+	}, nil
+}
+
+// decompressMessage decompresses the message data using the compressor or one of the built-in compressors.
+func decompressMessage(msg *message.Message, compressor Compressor, maxSize int64) error {
+	if msg.Encoding == "" {
+		return nil
+	}
+	if compressor == nil || compressor.Encoding() != msg.Encoding {
+		compressor = builtinCompressors[msg.Encoding]
+	}
+	if compressor == nil {
+		return fmt.Errorf("unsupported message encoding '%s'", msg.Encoding)
+	}
+	data, err := compressor.Decompress(msg.Data, maxSize)
+	if err != nil {
+		return err
+	}
+	msg.Data = data
+	msg.Encoding = ""
+	return nil
+}
+
+type compressingCodec struct {
+	codec      Codec[*message.Message]
+	compressor Compressor
+	threshold  int
+	maxSize    int64
+}
+
+// NewCompressingCodec compresses the data of messages which are at least threshold bytes long.
+// Decoding accepts uncompressed messages and messages compressed by any of the built-in compressors.
+// The decompressed data are limited to the MaxMessageSize of the connection config.
+// The codec offers FeatureCompression in the handshake, the messages are compressed only if the peer offers it too.
+func NewCompressingCodec(codec Codec[*message.Message], compressor Compressor, threshold int) Codec[*message.Message] {
+	return &compressingCodec{
+		codec:      codec,
+		compressor: compressor,
+		threshold:  threshold,
+		maxSize:    maxMessageSize,
+	}
+}
+
+func (c *compressingCodec) Encode(msg *message.Message) ([]byte, error) {
+	restore, err := compressMessage(msg, c.compressor, c.threshold)
+	if err != nil {
+		return nil, err
+	}
+	defer restore()
+	return c.codec.Encode(msg)
+}
+
+func (c *compressingCodec) Decode(data []byte, msg *message.Message) error {
+	if err := c.codec.Decode(data, msg); err != nil {
+		return err
+	}
+	return decompressMessage(msg, c.compressor, c.maxSize)
+}
+
+// withMaxSize returns a copy of the codec limiting the decompressed data to maxSize bytes.
+func (c *compressingCodec) withMaxSize(maxSize int64) Codec[*message.Message] {
+	clone := *c
+	clone.maxSize = maxSize
+	return &clone
+}
+
+// withMaxMessageSize applies the max message size of the connection to the codecs which decompress messages.
+func withMaxMessageSize(codec Codec[*message.Message], maxSize int64) Codec[*message.Message] {
+	if c, ok := codec.(*compressingCodec); ok && maxSize > 0 {
+		return c.withMaxSize(maxSize)
+	}
+	return codec
+}
+
+func (c *compressingCodec) IsBinary() bool {
+	return c.codec.IsBinary()
+}
+
+func (c *compressingCodec) Name() string {
+	return codecName(c.codec)
+}
@@ -0,0 +1,147 @@
+package ws
+
+import (
+	"bytes"
+	"compress/flate"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grepplabs/backstream/internal/message"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestCompressingCodec(t *testing.T) {
+	codecs := []Codec[*message.Message]{
+		NewJsonCodec[*message.Message](),
+		NewProtoCodec[*message.Message](),
+		NewMsgpackCodec[*message.Message](),
+		NewCborCodec[*message.Message](),
+	}
+	compressors := []Compressor{
+		NewGzipCompressor(flate.BestSpeed),
+		NewZstdCompressor(zstd.SpeedFastest),
+	}
+	large := bytes.Repeat([]byte(`{"key":"value"}`), 1000)
+	for _, codec := range codecs {
+		for _, compressor := range compressors {
+			t.Run(codecName(codec)+"_"+compressor.Encoding(), func(t *testing.T) {
+				compressing := NewCompressingCodec(codec, compressor, 1024)
+				require.Equal(t, codec.IsBinary(), compressing.IsBinary())
+				require.Equal(t, Subprotocol(codec), Subprotocol(compressing))
+
+				msg := &message.Message{Id: "1", Type: message.Message_REQUEST, Data: large}
+				data, err := compressing.Encode(msg)
+				require.NoError(t, err)
+				require.Less(t, len(data), len(large)/10)
+				require.Equal(t, large, msg.Data)
+				require.Empty(t, msg.Encoding)
+
+				var wire message.Message
+				require.NoError(t, codec.Decode(data, &wire))
+				require.Equal(t, compressor.Encoding(), wire.Encoding)
+
+				var decoded message.Message
+				require.NoError(t, compressing.Decode(data, &decoded))
+				require.True(t, proto.Equal(msg, &decoded))
+
+				small := &message.Message{Id: "2", Type: message.Message_REQUEST, Data: []byte("small")}
+				data, err = compressing.Encode(small)
+				require.NoError(t, err)
+				require.NoError(t, codec.Decode(data, &wire))
+				require.Empty(t, wire.Encoding)
+				require.NoError(t, compressing.Decode(data, &decoded))
+				require.True(t, proto.Equal(small, &decoded))
+			})
+		}
+	}
+}
+
+func TestCompressingCodecDecode(t *testing.T) {
+	codec := NewProtoCodec[*message.Message]()
+	compressing := NewCompressingCodec(codec, NewGzipCompressor(flate.DefaultCompression), 0)
+
+	// messages compressed by any built-in compressor are accepted
+	zstdCompressing := NewCompressingCodec(codec, NewZstdCompressor(zstd.SpeedDefault), 0)
+	data, err := zstdCompressing.Encode(&message.Message{Id: "1", Data: []byte("hello")})
+	require.NoError(t, err)
+	var msg message.Message
+	require.NoError(t, compressing.Decode(data, &msg))
+	require.Equal(t, []byte("hello"), msg.Data)
+
+	data, err = codec.Encode(&message.Message{Id: "1", Data: []byte("hello"), Encoding: "br"})
+	require.NoError(t, err)
+	require.ErrorContains(t, compressing.Decode(data, &msg), "unsupported message encoding")
+
+	for _, compressor := range []Compressor{NewGzipCompressor(flate.BestCompression), NewZstdCompressor(zstd.SpeedBestCompression)} {
+		bomb, err := compressor.Compress(make([]byte, maxMessageSize+1))
+		require.NoError(t, err)
+		data, err = codec.Encode(&message.Message{Id: "1", Data: bomb, Encoding: compressor.Encoding()})
+		require.NoError(t, err)
+		require.ErrorIs(t, compressing.Decode(data, &msg), ErrMessageTooLarge)
+	}
+
+	// the connection limit applies to the decompressed data
+	data, err = compressing.Encode(&message.Message{Id: "1", Data: make([]byte, 2048)})
+	require.NoError(t, err)
+	require.NoError(t, withMaxMessageSize(compressing, 2048).Decode(data, &msg))
+	require.ErrorIs(t, withMaxMessageSize(compressing, 1024).Decode(data, &msg), ErrMessageTooLarge)
+	require.Same(t, codec, withMaxMessageSize(codec, 1024))
+}
+
+func TestCompression(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	codec := NewCompressingCodec(NewJsonCodec[*message.Message](), NewZstdCompressor(zstd.SpeedDefault), 512)
+	serve := NewServe(ctx, &noopProxyHandler{}, codec, WithServeCompression(flate.BestSpeed))
+	server := httptest.NewServer(http.HandlerFunc(serve.HandleWS))
+	defer server.Close()
+
+	agent := &notifyRecorder{}
+	client := NewClient(ctx, "ws"+strings.TrimPrefix(server.URL, "http"), agent, codec, WithClientID("4711"), WithClientCompression(flate.BestSpeed))
+	_, err := client.GetConn()
+	require.NoError(t, err)
+
+	large := strings.Repeat("backstream", 1000)
+	conn := serve.GetConnByID("4711")
+	require.NotNil(t, conn)
+	require.True(t, conn.HasFeature(FeatureCompression))
+	require.NoError(t, conn.Notify(ctx, []byte(large)))
+	require.NoError(t, conn.Notify(ctx, []byte("small")))
+	require.Eventually(t, func() bool {
+		return len(agent.Received()) == 2
+	}, 3*time.Second, 10*time.Millisecond)
+	require.ElementsMatch(t, []string{large, "small"}, agent.Received())
+}
+
+func TestCompressionNotNegotiated(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	codec := NewJsonCodec[*message.Message]()
+	serve := NewServe(ctx, &noopProxyHandler{}, NewCompressingCodec(codec, NewZstdCompressor(zstd.SpeedDefault), 512))
+	server := httptest.NewServer(http.HandlerFunc(serve.HandleWS))
+	defer server.Close()
+
+	// the agent does not decompress the messages
+	agent := &notifyRecorder{}
+	client := NewClient(ctx, "ws"+strings.TrimPrefix(server.URL, "http"), agent, codec, WithClientID("4711"), WithClientFeatures(FeatureCompression))
+	_, err := client.GetConn()
+	require.NoError(t, err)
+
+	large := strings.Repeat("backstream", 1000)
+	conn := serve.GetConnByID("4711")
+	require.NotNil(t, conn)
+	require.False(t, conn.HasFeature(FeatureCompression))
+	require.NoError(t, conn.Notify(ctx, []byte(large)))
+	require.Eventually(t, func() bool {
+		return len(agent.Received()) == 1
+	}, 3*time.Second, 10*time.Millisecond)
+	require.Equal(t, []string{large}, agent.Received())
+}
@@ -0,0 +1,160 @@
+package ws
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+const (
+	// Time allowed to write a message to the peer.
+	defaultWriteWait = 10 * time.Second
+	// Send pings to peer with this period. Must be less than pongWait.
+	defaultPingPeriod = 10 * time.Second
+	// Time allowed to read the next pong message from the peer. Must be greater than pingPeriod.
+	defaultPongWait = (defaultPingPeriod * 2) + time.Second
+	// maximum message size allowed from peer.
+	maxMessageSize = 10 * 1024 * 1024
+	// number of outbound messages buffered by the connection.
+	defaultInFlightCount = 1024
+	// Time allowed to complete the in-flight messages of a draining connection.
+	defaultDrainTimeout = 30 * time.Second
+)
+
+var ErrInvalidConnConfig = errors.New("invalid connection config")
+
+// ConnConfig contains the connection timing and size parameters, zero values are replaced by the defaults.
+// The ping period is negotiated in the handshake, the connection pings with the shorter period of both sides.
+type ConnConfig struct {
+	// WriteWait is the time allowed to write a message to the peer.
+	WriteWait time.Duration
+	// PingPeriod is the period of the pings sent to the peer, it must be less than PongWait.
+	PingPeriod time.Duration
+	// PongWait is the time allowed to read the next pong message from the peer.
+	PongWait time.Duration
+	// MaxMessageSize is the maximum size of a message read from the peer.
+	MaxMessageSize int64
+	// InFlightCount is the number of outbound messages buffered by the connection per priority.
+	InFlightCount int
+	// PriorityWeights are the numbers of messages of the priorities sent in one scheduling round.
+	PriorityWeights map[Priority]int
+	// MaxAge is the connection age after which the connection is replaced and drained, zero disables it.
+	// A random jitter up to MaxAgeJitter is added, so the connections of the agents do not expire together.
+	MaxAge       time.Duration
+	MaxAgeJitter time.Duration
+	// IdleTimeout closes the connections without messages in either direction for the duration, zero disables it.
+	// It is applied only by the proxy, the agent would redial the closed connection right away.
+	IdleTimeout time.Duration
+	// DrainTimeout is the time allowed to complete the in-flight messages before a draining connection is closed.
+	DrainTimeout time.Duration
+}
+
+func DefaultConnConfig() ConnConfig {
+	return ConnConfig{
+		WriteWait:       defaultWriteWait,
+		PingPeriod:      defaultPingPeriod,
+		PongWait:        defaultPongWait,
+		MaxMessageSize:  maxMessageSize,
+		InFlightCount:   defaultInFlightCount,
+		PriorityWeights: defaultPriorityWeights(),
+		DrainTimeout:    defaultDrainTimeout,
+	}
+}
+
+func (c ConnConfig) withDefaults() ConnConfig {
+	defaults := DefaultConnConfig()
+	if c.WriteWait == 0 {
+		c.WriteWait = defaults.WriteWait
+	}
+	if c.PingPeriod == 0 {
+		c.PingPeriod = defaults.PingPeriod
+	}
+	if c.PongWait == 0 {
+		c.PongWait = max(defaults.PongWait, 2*c.PingPeriod+time.Second)
+	}
+	if c.MaxMessageSize == 0 {
+		c.MaxMessageSize = defaults.MaxMessageSize
+	}
+	if c.InFlightCount == 0 {
+		c.InFlightCount = defaults.InFlightCount
+	}
+	weights := defaults.PriorityWeights
+	for priority, weight := range c.PriorityWeights {
+		weights[priority] = weight
+	}
+	c.PriorityWeights = weights
+	if c.DrainTimeout == 0 {
+		c.DrainTimeout = defaults.DrainTimeout
+	}
+	return c
+}
+
+func (c ConnConfig) Validate() error {
+	if c.WriteWait < 0 || c.PingPeriod < 0 || c.PongWait < 0 ||
+		c.MaxAge < 0 || c.MaxAgeJitter < 0 || c.IdleTimeout < 0 || c.DrainTimeout < 0 {
+		return fmt.Errorf("%w: durations must not be negative", ErrInvalidConnConfig)
+	}
+	if c.MaxMessageSize < 0 || c.InFlightCount < 0 {
+		return fmt.Errorf("%w: sizes must not be negative", ErrInvalidConnConfig)
+	}
+	for priority, weight := range c.PriorityWeights {
+		if !priority.valid() || weight <= 0 {
+			return fmt.Errorf("%w: weight %d of priority %s must be positive", ErrInvalidConnConfig, weight, priority)
+		}
+	}
+	if c.PongWait <= c.PingPeriod {
+		return fmt.Errorf("%w: pong wait %s must be greater than ping period %s", ErrInvalidConnConfig, c.PongWait, c.PingPeriod)
+	}
+	return nil
+}
+
+// connConfigOrDefault applies the defaults, the invalid fields are logged and replaced by the defaults,
+// so the valid fields are kept.
+func connConfigOrDefault(config ConnConfig, logger *slog.Logger) ConnConfig {
+	invalid := func(field string, value any) {
+		logger.Error("invalid connection config, using the default", slog.String("field", field), slog.Any("value", value))
+	}
+	// zero values are replaced by the defaults or disable the feature
+	for field, value := range map[string]*time.Duration{
+		"WriteWait":    &config.WriteWait,
+		"PingPeriod":   &config.PingPeriod,
+		"PongWait":     &config.PongWait,
+		"MaxAge":       &config.MaxAge,
+		"MaxAgeJitter": &config.MaxAgeJitter,
+		"IdleTimeout":  &config.IdleTimeout,
+		"DrainTimeout": &config.DrainTimeout,
+	} {
+		if *value < 0 {
+			invalid(field, value.String())
+			*value = 0
+		}
+	}
+	if config.MaxMessageSize < 0 {
+		invalid("MaxMessageSize", config.MaxMessageSize)
+		config.MaxMessageSize = 0
+	}
+	if config.InFlightCount < 0 {
+		invalid("InFlightCount", config.InFlightCount)
+		config.InFlightCount = 0
+	}
+	weights := make(map[Priority]int, len(config.PriorityWeights))
+	for priority, weight := range config.PriorityWeights {
+		if !priority.valid() || weight <= 0 {
+			invalid("PriorityWeights", fmt.Sprintf("%s: %d", priority, weight))
+			continue
+		}
+		weights[priority] = weight
+	}
+	config.PriorityWeights = weights
+	config = config.withDefaults()
+	if config.PongWait <= config.PingPeriod {
+		invalid("PongWait", config.PongWait.String())
+		config.PongWait = max(defaultPongWait, 2*config.PingPeriod+time.Second)
+	}
+	if err := config.Validate(); err != nil {
+		logger.Error("invalid connection config, using the default", slog.String("error", err.Error()))
+		return DefaultConnConfig()
+	}
+	return config
+}
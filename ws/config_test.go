@@ -0,0 +1,79 @@
+package ws
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grepplabs/backstream/internal/message"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConnConfig(t *testing.T) {
+	require.Equal(t, DefaultConnConfig(), ConnConfig{}.withDefaults())
+	require.NoError(t, DefaultConnConfig().Validate())
+
+	config := ConnConfig{PingPeriod: 20 * time.Second}.withDefaults()
+	require.Equal(t, 41*time.Second, config.PongWait)
+	require.NoError(t, config.Validate())
+
+	require.ErrorIs(t, ConnConfig{PingPeriod: 5 * time.Second, PongWait: 5 * time.Second}.withDefaults().Validate(), ErrInvalidConnConfig)
+	require.ErrorIs(t, ConnConfig{WriteWait: -time.Second}.withDefaults().Validate(), ErrInvalidConnConfig)
+	require.ErrorIs(t, ConnConfig{InFlightCount: -1}.withDefaults().Validate(), ErrInvalidConnConfig)
+	require.ErrorIs(t, ConnConfig{MaxAge: -time.Second}.withDefaults().Validate(), ErrInvalidConnConfig)
+
+	// only the invalid fields are replaced by the defaults
+	client := NewClient(context.Background(), "ws://localhost", &notifyRecorder{}, NewJsonCodec[*message.Message](), WithClientConnConfig(ConnConfig{PingPeriod: time.Second, PongWait: time.Second}))
+	expected := DefaultConnConfig()
+	expected.PingPeriod = time.Second
+	require.Equal(t, expected, client.connConfig)
+	serve := NewServe(context.Background(), &noopProxyHandler{}, NewJsonCodec[*message.Message](), WithServeConnConfig(ConnConfig{
+		InFlightCount:   -1,
+		MaxMessageSize:  1024,
+		MaxAge:          -time.Second,
+		DrainTimeout:    -time.Second,
+		PriorityWeights: map[Priority]int{PriorityHigh: 0, PriorityLow: 2},
+	}))
+	expected = DefaultConnConfig()
+	expected.MaxMessageSize = 1024
+	expected.PriorityWeights[PriorityLow] = 2
+	require.Equal(t, expected, serve.connConfig)
+	serve = NewServe(context.Background(), &noopProxyHandler{}, NewJsonCodec[*message.Message](), WithServeConnConfig(ConnConfig{PongWait: 5 * time.Second}))
+	require.Equal(t, DefaultConnConfig(), serve.connConfig)
+}
+
+func TestConnConfigPingPeriod(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	codec := NewProtoCodec[*message.Message]()
+	serve := NewServe(ctx, &noopProxyHandler{}, codec, WithServeConnConfig(ConnConfig{PongWait: 300 * time.Millisecond, PingPeriod: 200 * time.Millisecond}))
+	server := httptest.NewServer(http.HandlerFunc(serve.HandleWS))
+	defer server.Close()
+
+	agent := &notifyRecorder{}
+	client := NewClient(ctx, "ws"+strings.TrimPrefix(server.URL, "http"), agent, codec, WithClientID("4711"),
+		WithClientConnConfig(ConnConfig{PingPeriod: 50 * time.Millisecond, PongWait: 300 * time.Millisecond, MaxMessageSize: 1024}))
+	conn, err := client.GetConn()
+	require.NoError(t, err)
+	require.Equal(t, 50*time.Millisecond, conn.Capabilities().PingPeriod)
+	require.Equal(t, int64(1024), conn.Capabilities().MaxMessageSize)
+
+	serverConn := serve.GetConnByID("4711")
+	require.NotNil(t, serverConn)
+	require.Equal(t, 50*time.Millisecond, serverConn.Capabilities().PingPeriod)
+
+	// the connection is kept alive by the pings
+	time.Sleep(time.Second)
+	require.Same(t, serverConn, serve.GetConnByID("4711"))
+	require.NoError(t, serverConn.Notify(ctx, []byte("alive")))
+	require.Eventually(t, func() bool {
+		return len(agent.Received()) == 1
+	}, 3*time.Second, 10*time.Millisecond)
+
+	_, err = serverConn.Send(ctx, make([]byte, 2048))
+	require.ErrorIs(t, err, ErrMessageTooLarge)
+}
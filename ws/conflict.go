@@ -0,0 +1,186 @@
+package ws
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync/atomic"
+)
+
+const HeaderInstanceId = "x-backstream-instance-id"
+
+// CloseInstanceReplaced is the WebSocket close code of the connections replaced by another agent instance,
+// the replaced agent does not reconnect.
+const CloseInstanceReplaced = 4409
+
+var (
+	ErrClientIDConflict = errors.New("client ID conflict")
+	ErrInstanceReplaced = errors.New("agent instance replaced")
+)
+
+// ConflictPolicy decides how the proxy handles an agent connecting with the client ID of another agent instance.
+// Connections of the same instance, e.g. the replacements of expired connections, are not conflicts.
+type ConflictPolicy int
+
+const (
+	// ConflictAllowMultiple keeps the connections of all instances, the requests go to either of them.
+	ConflictAllowMultiple ConflictPolicy = iota
+	// ConflictRejectNew rejects the connections of a new instance while another instance is connected
+	// or connecting to this replica. Instances connected to other replicas in the cluster mode are not checked.
+	// The instance ID is chosen by the agent, an agent presenting the instance ID of the connected one is accepted.
+	// Use the instance ID only together with an authentication of the client ID, e.g. WithServeClientIDResolver.
+	ConflictRejectNew
+	// ConflictReplaceOldest accepts the connections of a new instance and closes the connections of the other instances
+	// with CloseInstanceReplaced. The replaced agent stops reconnecting, so the instances do not replace each other.
+	ConflictReplaceOldest
+	// ConflictRequireInstanceID rejects the connections without the instance ID. The client ID and the instance ID
+	// together identify the agent, so the instances are not conflicts and can be addressed by the
+	// x-backstream-instance-id header of the proxy requests.
+	ConflictRequireInstanceID
+)
+
+// ConflictAction is the action taken on a conflict.
+type ConflictAction int
+
+const (
+	ConflictAllowed ConflictAction = iota
+	ConflictRejected
+	ConflictReplaced
+)
+
+func (a ConflictAction) String() string {
+	switch a {
+	case ConflictAllowed:
+		return "allowed"
+	case ConflictRejected:
+		return "rejected"
+	case ConflictReplaced:
+		return "replaced"
+	}
+	return fmt.Sprintf("ConflictAction(%d)", int(a))
+}
+
+// ConflictEvent describes a connection request conflicting with the connected instances of the client ID.
+type ConflictEvent struct {
+	ClientID            string
+	InstanceID          string
+	ExistingInstanceIDs []string
+	RemoteAddr          string
+	Action              ConflictAction
+}
+
+// ConflictMetrics is a snapshot of the conflict counters.
+type ConflictMetrics struct {
+	Allowed  uint64
+	Rejected uint64
+	Replaced uint64
+}
+
+type conflictCounters struct {
+	allowed  atomic.Uint64
+	rejected atomic.Uint64
+	replaced atomic.Uint64
+}
+
+// ConflictMetrics returns the number of conflicts by the action taken.
+func (s *Serve) ConflictMetrics() ConflictMetrics {
+	return ConflictMetrics{
+		Allowed:  s.conflicts.allowed.Load(),
+		Rejected: s.conflicts.rejected.Load(),
+		Replaced: s.conflicts.replaced.Load(),
+	}
+}
+
+// checkConflict applies the conflict policy to the connection request and reserves the client ID for the new
+// connection, the reservation must be released after the connection is registered. It returns false if the request
+// was rejected. The conflicts are checked only against the connections of this replica.
+func (s *Serve) checkConflict(w http.ResponseWriter, r *http.Request, clientID string, instanceID string) (*reservation, bool) {
+	if s.conflictPolicy == ConflictRequireInstanceID && instanceID == "" {
+		http.Error(w, fmt.Sprintf("header %s is required", HeaderInstanceId), http.StatusBadRequest)
+		return nil, false
+	}
+	if clientID == "" {
+		return nil, true
+	}
+	var event *ConflictEvent
+	res := s.pool.reserve(clientID, instanceID, func(instanceIDs []string) bool {
+		existing := s.conflictingInstances(instanceID, instanceIDs)
+		if len(existing) == 0 {
+			return true
+		}
+		event = &ConflictEvent{
+			ClientID:            clientID,
+			InstanceID:          instanceID,
+			ExistingInstanceIDs: existing,
+			RemoteAddr:          r.RemoteAddr,
+			Action:              s.conflictAction(),
+		}
+		return event.Action != ConflictRejected
+	})
+	if event != nil {
+		s.reportConflict(event)
+	}
+	if res == nil {
+		http.Error(w, fmt.Sprintf("%s: client ID %s is connected by another instance", ErrClientIDConflict, clientID), http.StatusConflict)
+		return nil, false
+	}
+	return res, true
+}
+
+// conflictingInstances returns the instance IDs conflicting with the new instance.
+func (s *Serve) conflictingInstances(instanceID string, instanceIDs []string) (result []string) {
+	// the client ID and the instance ID together identify the agent
+	if s.conflictPolicy == ConflictRequireInstanceID {
+		return nil
+	}
+	for _, id := range instanceIDs {
+		// connections without the instance ID cannot be told apart
+		if instanceID == "" || id != instanceID {
+			result = append(result, id)
+		}
+	}
+	return result
+}
+
+func (s *Serve) conflictAction() ConflictAction {
+	switch s.conflictPolicy {
+	case ConflictRejectNew:
+		return ConflictRejected
+	case ConflictReplaceOldest:
+		return ConflictReplaced
+	}
+	return ConflictAllowed
+}
+
+func (s *Serve) reportConflict(event *ConflictEvent) {
+	switch event.Action {
+	case ConflictRejected:
+		s.conflicts.rejected.Add(1)
+	case ConflictReplaced:
+		s.conflicts.replaced.Add(1)
+	default:
+		s.conflicts.allowed.Add(1)
+	}
+	s.logger.Warn("client ID conflict", slog.String("client-id", event.ClientID), slog.String("instance-id", event.InstanceID),
+		slog.Any("existing-instance-ids", event.ExistingInstanceIDs), slog.String("remote-addr", event.RemoteAddr), slog.String("action", event.Action.String()))
+	if s.conflictHandler != nil {
+		s.conflictHandler(*event)
+	}
+}
+
+// replaceOlderInstances closes the connections of the other instances registered before the new connection,
+// so the newest instance wins also when the instances connect concurrently.
+func (s *Serve) replaceOlderInstances(conn *Conn) {
+	for _, old := range s.pool.olderInstances(conn) {
+		conn.logger.Info("closing connection replaced by another instance", slog.String("instance-id", old.instanceID))
+		old.closeWith(CloseInstanceReplaced, "replaced by another instance")
+	}
+}
+
+// instanceReplaced stops reconnecting the agent replaced by another instance.
+func (c *Client) instanceReplaced(conn *Conn) {
+	if !c.replaced.Swap(true) {
+		c.logger.Error("agent instance replaced by another instance, not reconnecting", slog.String("url", conn.endpoint))
+	}
+}
@@ -0,0 +1,160 @@
+package ws
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/grepplabs/backstream/internal/message"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConflictPolicy(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	codec := NewProtoCodec[*message.Message]()
+	newServer := func(policy ConflictPolicy) (*Serve, string, func() []ConflictEvent) {
+		var mu sync.Mutex
+		var events []ConflictEvent
+		serve := NewServe(ctx, &noopProxyHandler{}, codec, WithServeConflictPolicy(policy), WithServeConflictHandler(func(event ConflictEvent) {
+			mu.Lock()
+			defer mu.Unlock()
+			events = append(events, event)
+		}))
+		server := httptest.NewServer(http.HandlerFunc(serve.HandleWS))
+		t.Cleanup(server.Close)
+		return serve, "ws" + strings.TrimPrefix(server.URL, "http"), func() []ConflictEvent {
+			mu.Lock()
+			defer mu.Unlock()
+			return append([]ConflictEvent(nil), events...)
+		}
+	}
+	connect := func(serverURL string, instanceID string) error {
+		_, err := NewClient(ctx, serverURL, &notifyRecorder{}, codec, WithClientID("4711"), WithClientInstanceID(instanceID)).GetConn()
+		return err
+	}
+
+	t.Run("allow multiple", func(t *testing.T) {
+		serve, serverURL, events := newServer(ConflictAllowMultiple)
+		require.NoError(t, connect(serverURL, "a"))
+		require.NoError(t, connect(serverURL, "b"))
+		require.Len(t, serve.GetConnsByID("4711"), 2)
+		require.Equal(t, ConflictMetrics{Allowed: 1}, serve.ConflictMetrics())
+		require.Equal(t, []ConflictEvent{{ClientID: "4711", InstanceID: "b", ExistingInstanceIDs: []string{"a"}, RemoteAddr: events()[0].RemoteAddr, Action: ConflictAllowed}}, events())
+	})
+	t.Run("reject new", func(t *testing.T) {
+		serve, serverURL, events := newServer(ConflictRejectNew)
+		require.NoError(t, connect(serverURL, "a"))
+		require.ErrorContains(t, connect(serverURL, "b"), "status: 409")
+		// same instance
+		require.NoError(t, connect(serverURL, "a"))
+		require.Len(t, serve.GetConnsByID("4711"), 2)
+		require.Equal(t, ConflictMetrics{Rejected: 1}, serve.ConflictMetrics())
+		require.Len(t, events(), 1)
+		require.Equal(t, ConflictRejected, events()[0].Action)
+	})
+	t.Run("replace oldest", func(t *testing.T) {
+		serve, serverURL, _ := newServer(ConflictReplaceOldest)
+		require.NoError(t, connect(serverURL, "a"))
+		require.NoError(t, connect(serverURL, "b"))
+		require.Eventually(t, func() bool {
+			conns := serve.GetConnsByID("4711")
+			return len(conns) == 1 && conns[0].InstanceID() == "b"
+		}, 3*time.Second, 10*time.Millisecond)
+		require.Equal(t, ConflictMetrics{Replaced: 1}, serve.ConflictMetrics())
+	})
+	t.Run("require instance ID", func(t *testing.T) {
+		serve, serverURL, events := newServer(ConflictRequireInstanceID)
+		require.ErrorContains(t, connect(serverURL, ""), "status: 400")
+		require.NoError(t, connect(serverURL, "a"))
+		require.NoError(t, connect(serverURL, "b"))
+		require.Len(t, serve.GetConnsByID("4711"), 2)
+		require.Equal(t, ConflictMetrics{}, serve.ConflictMetrics())
+		require.Empty(t, events())
+
+		// the instances are addressed by the instance ID
+		r := httptest.NewRequest(http.MethodGet, "/test", nil)
+		r.Header.Set(HeaderInstanceId, "b")
+		conns, err := serve.matchConns(r, "4711")
+		require.NoError(t, err)
+		require.Len(t, conns, 1)
+		require.Equal(t, "b", conns[0].InstanceID())
+	})
+	t.Run("concurrent reject new", func(t *testing.T) {
+		serve, serverURL, _ := newServer(ConflictRejectNew)
+		var wg sync.WaitGroup
+		errs := make([]error, 10)
+		for i := range errs {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				errs[i] = connect(serverURL, strconv.Itoa(i))
+			}(i)
+		}
+		wg.Wait()
+		var connected int
+		for _, err := range errs {
+			if err == nil {
+				connected++
+			} else {
+				require.ErrorContains(t, err, "status: 409")
+			}
+		}
+		require.Equal(t, 1, connected)
+		require.Len(t, serve.GetConnsByID("4711"), 1)
+		require.Equal(t, ConflictMetrics{Rejected: 9}, serve.ConflictMetrics())
+	})
+	t.Run("concurrent replace oldest", func(t *testing.T) {
+		serve, serverURL, _ := newServer(ConflictReplaceOldest)
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				_ = connect(serverURL, strconv.Itoa(i))
+			}(i)
+		}
+		wg.Wait()
+		require.Eventually(t, func() bool {
+			return len(serve.GetConnsByID("4711")) == 1
+		}, 3*time.Second, 10*time.Millisecond)
+	})
+}
+
+func TestConflictReplaceOldestClients(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	codec := NewProtoCodec[*message.Message]()
+	serve := NewServe(ctx, &noopProxyHandler{}, codec, WithServeConflictPolicy(ConflictReplaceOldest))
+	server := httptest.NewServer(http.HandlerFunc(serve.HandleWS))
+	defer server.Close()
+	serverURL := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	oldest := NewClient(ctx, serverURL, &notifyRecorder{}, codec, WithClientID("4711"), WithClientInstanceID("a"))
+	oldest.Start()
+	require.Eventually(t, func() bool {
+		return serve.GetConnByID("4711") != nil
+	}, 3*time.Second, 10*time.Millisecond)
+
+	newest := NewClient(ctx, serverURL, &notifyRecorder{}, codec, WithClientID("4711"), WithClientInstanceID("b"))
+	newest.Start()
+	isNewest := func() bool {
+		conns := serve.GetConnsByID("4711")
+		return len(conns) == 1 && conns[0].InstanceID() == "b"
+	}
+	require.Eventually(t, isNewest, 3*time.Second, 10*time.Millisecond)
+
+	// the replaced instance does not reconnect
+	time.Sleep(2500 * time.Millisecond)
+	require.True(t, isNewest())
+	require.Equal(t, ConflictMetrics{Replaced: 1}, serve.ConflictMetrics())
+	_, err := oldest.GetConn()
+	require.ErrorIs(t, err, ErrInstanceReplaced)
+}
@@ -4,26 +4,19 @@ import (
 	"bytes"
 	"context"
 	"errors"
+	"fmt"
 	"io"
 	"log/slog"
+	"net/http"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 	"github.com/grepplabs/backstream/internal/message"
 	"github.com/grepplabs/backstream/internal/util"
-)
-
-const (
-	// Time allowed to write a message to the peer.
-	writeWait = 10 * time.Second
-	// Send pings to peer with this period. Must be less than pongWait.
-	pingPeriod = 10 * time.Second
-	// Time allowed to read the next pong message from the peer. Must be greater than pingPeriod.
-	pongWait = (pingPeriod * 2) + time.Second
-	// maximum message size allowed from peer.
-	maxMessageSize = 10 * 1024 * 1024
+	"google.golang.org/protobuf/proto"
 )
 
 var ErrConnectionClosed = errors.New("connection closed")
@@ -35,38 +28,101 @@ type EventHandler interface {
 
 type Conn struct {
 	pool *Pool
+	// registration sequence in the pool
+	seq uint64
 	// client ID
 	clientID string
+	// labels advertised by the agent
+	labels map[string]string
+	// proxy URL dialed by the agent, empty on the proxy side
+	endpoint string
+	// agent instance ID, empty if not sent by the agent
+	instanceID string
 	// The websocket connection.
 	conn *websocket.Conn
-	// Buffered channel of outbound messages.
-	sendCh chan []byte
+	// Bounded queues of outbound messages.
+	sendQueue *sendQueue
 	// Buffered channels of response messages.
-	respMap *util.SyncedMap[string, chan []byte]
+	respMap *util.SyncedMap[string, chan *message.Message]
 	// Send channel closer
 	sendClose func()
+	// closed when the reader exits
+	done chan struct{}
 	// Cancel function
 	cancel context.CancelFunc
 	// Message Handler
 	handler EventHandler
 	// codec
 	codec Codec[*message.Message]
+	// capabilities of this side
+	localCapabilities *Capabilities
+	// capabilities negotiated with the peer
+	capabilities atomic.Pointer[Capabilities]
+	// message signing, nil if disabled
+	signing *messageSigning
+	// number of messages rejected by the message signing
+	rejected atomic.Int32
+	// timing and size parameters
+	config ConnConfig
+	// limits handled notifications and requests, nil if unlimited
+	limiter *concurrencyLimiter
+	// ping period negotiated in the handshake
+	pingPeriodCh chan time.Duration
+	// set when this side or the peer drains the connection
+	draining  atomic.Bool
+	drainOnce sync.Once
+	// called when the peer drains the connection, nil if not set
+	onPeerDrain func(*Conn)
+	// called when the proxy asks the agent to reconnect, nil if not set
+	onGoAway func(conn *Conn, hintURL string)
+	// called when the proxy closed the connection replaced by another instance, nil if not set
+	onReplaced func(*Conn)
+	// close frame sent when the connection is closed, empty if not set
+	closeFrame atomic.Pointer[[]byte]
+	// number of messages handled or awaiting the response
+	inFlight atomic.Int64
+	// time of the last message in unix nanoseconds
+	lastActive atomic.Int64
 	// logger
 	logger *slog.Logger
 }
 
+func (c *Conn) ClientID() string {
+	return c.clientID
+}
+
+// InstanceID returns the ID of the agent instance, it tells apart agents with the same client ID.
+func (c *Conn) InstanceID() string {
+	return c.instanceID
+}
+
+// Subprotocol returns the negotiated WebSocket subprotocol, it selects the connection codec.
+func (c *Conn) Subprotocol() string {
+	return c.conn.Subprotocol()
+}
+
+// Labels returns a copy of the labels advertised by the agent.
+func (c *Conn) Labels() map[string]string {
+	labels := make(map[string]string, len(c.labels))
+	for k, v := range c.labels {
+		labels[k] = v
+	}
+	return labels
+}
+
 func (c *Conn) readLoop(ctx context.Context) {
 	defer func() {
 		c.pool.unregister(c)
 		c.sendClose()
+		close(c.done)
 		_ = c.conn.Close()
 		c.logger.Debug("Reader closed")
 	}()
-	c.conn.SetReadLimit(maxMessageSize)
-	_ = c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	c.conn.SetReadLimit(c.config.MaxMessageSize)
+	_ = c.conn.SetReadDeadline(time.Now().Add(c.config.PongWait))
 	c.conn.SetPongHandler(func(string) error {
 		c.logger.Debug("Received pong")
-		return c.conn.SetReadDeadline(time.Now().Add(pongWait))
+		return c.conn.SetReadDeadline(time.Now().Add(c.config.PongWait))
 	})
 	for {
 		msgType, msg, err := c.conn.ReadMessage()
@@ -76,6 +132,9 @@ func (c *Conn) readLoop(ctx context.Context) {
 			} else {
 				c.logger.Warn("read message unexpected close", slog.String("error", err.Error()))
 			}
+			if websocket.IsCloseError(err, CloseInstanceReplaced) && c.onReplaced != nil {
+				c.onReplaced(c)
+			}
 			break
 		}
 		if msgType == websocket.BinaryMessage {
@@ -83,99 +142,202 @@ func (c *Conn) readLoop(ctx context.Context) {
 		} else {
 			c.logger.Debug("Received message : " + string(msg))
 		}
+		c.touch()
+		received, ok := c.decodeReceived(msg)
+		if !ok || !c.admit(ctx, received) {
+			continue
+		}
+		c.inFlight.Add(1)
 		go func() {
+			defer c.inFlight.Add(-1)
 			// long-lasting handleReceived blocks pong response as conn.ReadMessage() is not invoked
-			resp := c.handleReceived(ctx, msg)
+			resp, priority := c.handleReceived(ctx, received)
 			if resp != nil {
 				if msgType == websocket.BinaryMessage {
 					c.logger.Debug("Sending response")
 				} else {
 					c.logger.Debug("Sending response : " + string(resp))
 				}
-				closed, err := safeSend(ctx, c.sendCh, resp)
-				if err != nil {
+				if err := c.sendQueue.push(ctx, priority, resp, true); err != nil {
 					c.logger.Warn("readLoop send failure", slog.String("error", err.Error()))
 					_ = c.conn.Close()
 					return
 				}
-				if closed {
-					c.logger.Warn("Channel closed")
-					_ = c.conn.Close()
-					return
-				}
 			}
 		}()
 	}
 }
 
 func (c *Conn) writeLoop(ctx context.Context) {
-	ticker := time.NewTicker(pingPeriod)
+	ticker := time.NewTicker(c.config.PingPeriod)
 	defer func() {
 		ticker.Stop()
 		_ = c.conn.Close()
 		c.logger.Debug("Writer closed")
 	}()
+	ping := func() error {
+		c.logger.Debug("Sending ping")
+		_ = c.conn.SetWriteDeadline(time.Now().Add(c.config.WriteWait))
+		return c.conn.WriteMessage(websocket.PingMessage, nil)
+	}
 	for {
 		select {
 		case <-ctx.Done():
-			_ = c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			closeFrame := []byte{}
+			if frame := c.closeFrame.Load(); frame != nil {
+				closeFrame = *frame
+			}
+			_ = c.conn.SetWriteDeadline(time.Now().Add(c.config.WriteWait))
+			_ = c.conn.WriteMessage(websocket.CloseMessage, closeFrame)
+			return
+		case <-c.sendQueue.closed:
+			// The send queue was closed.
+			_ = c.conn.SetWriteDeadline(time.Now().Add(c.config.WriteWait))
 			_ = c.conn.WriteMessage(websocket.CloseMessage, []byte{})
 			return
-		case msg, ok := <-c.sendCh:
-			if c.codec.IsBinary() {
-				c.logger.Debug("Sending")
-			} else {
-				c.logger.Debug("Sending : " + string(msg))
-			}
-			_ = c.conn.SetWriteDeadline(time.Now().Add(writeWait))
-			if !ok {
-				// The send channel was closed .
-				_ = c.conn.WriteMessage(websocket.CloseMessage, []byte{})
-				return
-			}
-			messageType := websocket.BinaryMessage
-			if !c.codec.IsBinary() {
-				messageType = websocket.TextMessage
-			}
-			w, err := c.conn.NextWriter(messageType)
-			if err != nil {
-				return
-			}
-			_, err = io.Copy(w, bytes.NewReader(msg))
-			if err != nil {
-				return
-			}
-			if err := w.Close(); err != nil {
-				return
+		case <-c.sendQueue.ready:
+			for ctx.Err() == nil {
+				msg, ok := c.sendQueue.next()
+				if !ok {
+					break
+				}
+				if err := c.writeMessage(msg); err != nil {
+					return
+				}
+				// keep pinging while the queues are busy
+				select {
+				case <-ticker.C:
+					if err := ping(); err != nil {
+						return
+					}
+				default:
+				}
 			}
+		case period := <-c.pingPeriodCh:
+			c.logger.Debug("ping period " + period.String())
+			ticker.Reset(period)
 		case <-ticker.C:
-			c.logger.Debug("Sending ping")
-			_ = c.conn.SetWriteDeadline(time.Now().Add(writeWait))
-			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+			if err := ping(); err != nil {
 				return
 			}
 		}
 	}
 }
 
+func (c *Conn) writeMessage(msg []byte) error {
+	c.touch()
+	if c.codec.IsBinary() {
+		c.logger.Debug("Sending")
+	} else {
+		c.logger.Debug("Sending : " + string(msg))
+	}
+	_ = c.conn.SetWriteDeadline(time.Now().Add(c.config.WriteWait))
+	messageType := websocket.BinaryMessage
+	if !c.codec.IsBinary() {
+		messageType = websocket.TextMessage
+	}
+	w, err := c.conn.NextWriter(messageType)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(w, bytes.NewReader(msg)); err != nil {
+		return err
+	}
+	return w.Close()
+}
+
 func (c *Conn) Close() {
 	c.logger.Debug("closing connection")
 	c.cancel()
 }
 
-func (c *Conn) handleReceived(ctx context.Context, input []byte) []byte {
+// closeWith closes the connection with the close code.
+func (c *Conn) closeWith(code int, text string) {
+	closeFrame := websocket.FormatCloseMessage(code, text)
+	c.closeFrame.Store(&closeFrame)
+	c.Close()
+}
+
+// decodeReceived decodes and verifies the incoming message.
+func (c *Conn) decodeReceived(input []byte) (*message.Message, bool) {
 	var msg message.Message
 	err := c.codec.Decode(input, &msg)
 	if err != nil {
 		c.logger.Error(err.Error())
-		return nil
+		return nil, false
+	}
+	if c.signing != nil {
+		if err = c.signing.verify(&msg); err != nil {
+			c.logger.Warn("message rejected", slog.String("id", msg.Id), slog.String("type", msg.Type.String()), slog.String("error", err.Error()))
+			if c.rejected.Add(1) >= maxRejectedMessages {
+				c.logger.Warn("closing connection, too many rejected messages", slog.Int("rejected", maxRejectedMessages))
+				c.Close()
+			}
+			return nil, false
+		}
+	}
+	return &msg, true
+}
+
+// admit takes a queue slot of the concurrency limiter for the notifications and requests before their handler
+// goroutine is started. Requests exceeding the queue are answered with 503, notifications are dropped.
+func (c *Conn) admit(ctx context.Context, msg *message.Message) bool {
+	if c.limiter == nil || (msg.Type != message.Message_NOTIFY && msg.Type != message.Message_REQUEST) {
+		return true
+	}
+	if c.limiter.admit() {
+		return true
+	}
+	c.logger.Warn("message rejected, too many messages in progress", slog.String("id", msg.Id), slog.String("type", msg.Type.String()))
+	if msg.Type == message.Message_REQUEST {
+		if resp := c.encodeError(msg.Id, http.StatusServiceUnavailable, "agent overloaded"); resp != nil {
+			if err := c.sendQueue.push(ctx, Priority(msg.Priority), resp, false); err != nil {
+				c.logger.Warn("overload response dropped", slog.String("id", msg.Id), slog.String("error", err.Error()))
+			}
+		}
+	}
+	return false
+}
+
+// handleReceived handles the incoming message and returns the response with its priority.
+func (c *Conn) handleReceived(ctx context.Context, msg *message.Message) ([]byte, Priority) {
+	if msg.Type == message.Message_REQUEST || msg.Type == message.Message_NOTIFY {
+		// the handlers and the response inherit the priority of the request
+		priority := Priority(msg.Priority)
+		return c.handleMessage(WithPriority(ctx, priority), msg), priority
+	}
+	return c.handleMessage(ctx, msg), PriorityHigh
+}
+
+func (c *Conn) handleMessage(ctx context.Context, msg *message.Message) []byte {
+	if c.limiter != nil && (msg.Type == message.Message_NOTIFY || msg.Type == message.Message_REQUEST) {
+		// the message was admitted by the read loop
+		if !c.limiter.acquire(ctx) {
+			return nil
+		}
+		defer c.limiter.release()
 	}
 	switch msg.Type {
 	case message.Message_NOTIFY:
+		if msg.Ack && c.pool.acked.Contains(msg.Id) {
+			c.logger.Debug("acknowledging already handled notification " + msg.Id)
+			return c.encodeAck(msg.Id)
+		}
+		if msg.Ack {
+			ctx = context.WithValue(ctx, notificationIDKey{}, msg.Id)
+		}
 		err := c.handler.HandleNotify(ctx, msg.Data)
 		if err != nil {
+			if msg.Ack {
+				// negative acknowledgement, the sender does not wait for the timeout
+				return c.encodeError(msg.Id, http.StatusInternalServerError, err.Error())
+			}
 			return nil
 		}
+		if msg.Ack {
+			c.pool.acked.Add(msg.Id)
+			return c.encodeAck(msg.Id)
+		}
 	case message.Message_REQUEST:
 		output, err := c.handler.HandleRequest(ctx, msg.Data)
 		if err != nil {
@@ -183,46 +345,137 @@ func (c *Conn) handleReceived(ctx context.Context, input []byte) []byte {
 		}
 		msg.Data = output
 		msg.Type = message.Message_RESPONSE
-		data, err := c.codec.Encode(&msg)
+		data, err := c.encode(msg)
 		if err != nil {
+			c.logger.Error(err.Error())
 			return nil
 		}
 		return data
-	case message.Message_RESPONSE:
+	case message.Message_HELLO:
+		return c.handleHello(msg)
+	case message.Message_DRAIN:
+		c.handleDrain()
+	case message.Message_GOAWAY:
+		c.handleGoAway(msg)
+	case message.Message_RESPONSE, message.Message_ACK, message.Message_WELCOME, message.Message_ERROR:
 		// if no handlerFunc found means, that client received timeout and removed it
 		if respCh, ok := c.respMap.Get(msg.Id); ok {
-			respCh <- msg.Data
+			respCh <- msg
 		}
 	}
 	return nil
 }
 
+// encode encodes the outgoing message and checks it against the size accepted by the peer.
+func (c *Conn) encode(msg *message.Message) ([]byte, error) {
+	data, err := c.marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	if limit := c.capabilities.Load().MaxMessageSize; limit > 0 && int64(len(data)) > limit {
+		return nil, fmt.Errorf("%w: %d bytes exceeds the limit of %d bytes", ErrMessageTooLarge, len(data), limit)
+	}
+	return data, nil
+}
+
+// marshal signs and encodes the message.
+func (c *Conn) marshal(msg *message.Message) ([]byte, error) {
+	if c.signing != nil {
+		if err := c.signing.sign(msg); err != nil {
+			return nil, err
+		}
+	}
+	if codec, ok := c.codec.(*compressingCodec); ok && !c.HasFeature(FeatureCompression) {
+		// the peer did not offer to decompress the messages
+		return codec.codec.Encode(msg)
+	}
+	return c.codec.Encode(msg)
+}
+
+func (c *Conn) encodeAck(id string) []byte {
+	data, err := c.marshal(&message.Message{
+		Id:   id,
+		Type: message.Message_ACK,
+	})
+	if err != nil {
+		c.logger.Error(err.Error())
+		return nil
+	}
+	return data
+}
+
+func (c *Conn) encodeError(id string, code int, text string) []byte {
+	data, err := proto.Marshal(&message.Error{
+		Code:    int32(code),
+		Message: text,
+	})
+	if err != nil {
+		c.logger.Error(err.Error())
+		return nil
+	}
+	output, err := c.marshal(&message.Message{
+		Id:   id,
+		Type: message.Message_ERROR,
+		Data: data,
+	})
+	if err != nil {
+		c.logger.Error(err.Error())
+		return nil
+	}
+	return output
+}
+
 func (c *Conn) Send(ctx context.Context, input []byte) ([]byte, error) {
 	msg := &message.Message{
-		Id:   uuid.New().String(),
-		Type: message.Message_REQUEST,
-		Data: input,
+		Id:       uuid.New().String(),
+		Type:     message.Message_REQUEST,
+		Data:     input,
+		Priority: int32(PriorityFromContext(ctx)),
+	}
+	return c.sendAndWait(ctx, msg)
+}
+
+// notifyAck sends the notification and waits until the peer acknowledges it.
+func (c *Conn) notifyAck(ctx context.Context, id string, input []byte) error {
+	msg := &message.Message{
+		Id:       id,
+		Type:     message.Message_NOTIFY,
+		Data:     input,
+		Ack:      true,
+		Priority: int32(PriorityFromContext(ctx)),
 	}
-	data, err := c.codec.Encode(msg)
+	_, err := c.sendAndWait(ctx, msg)
+	return err
+}
+
+func (c *Conn) sendAndWait(ctx context.Context, msg *message.Message) ([]byte, error) {
+	data, err := c.encode(msg)
 	if err != nil {
 		return nil, err
 	}
-	respCh := make(chan []byte, 1)
+	respCh := make(chan *message.Message, 1)
 	c.respMap.Set(msg.Id, respCh)
+	c.inFlight.Add(1)
 	defer func() {
 		c.respMap.Delete(msg.Id)
+		c.inFlight.Add(-1)
 	}()
 
-	closed, err := safeSend(ctx, c.sendCh, data)
-	if err != nil {
+	if err = c.sendQueue.push(ctx, Priority(msg.Priority), data, false); err != nil {
 		return nil, err
 	}
-	if closed {
-		return nil, ErrConnectionClosed
-	}
 	select {
 	case resp := <-respCh:
-		return resp, nil
+		if resp.Type == message.Message_ERROR {
+			var peerErr message.Error
+			if err = proto.Unmarshal(resp.Data, &peerErr); err != nil {
+				return nil, err
+			}
+			return nil, &PeerError{Code: int(peerErr.Code), Message: peerErr.Message}
+		}
+		return resp.Data, nil
+	case <-c.done:
+		return nil, ErrConnectionClosed
 	case <-ctx.Done():
 		return nil, ctx.Err()
 	}
@@ -230,64 +483,84 @@ func (c *Conn) Send(ctx context.Context, input []byte) ([]byte, error) {
 
 func (c *Conn) Notify(ctx context.Context, input []byte) error {
 	msg := &message.Message{
-		Id:   uuid.New().String(),
-		Type: message.Message_NOTIFY,
-		Data: input,
+		Id:       uuid.New().String(),
+		Type:     message.Message_NOTIFY,
+		Data:     input,
+		Priority: int32(PriorityFromContext(ctx)),
 	}
-	data, err := c.codec.Encode(msg)
+	data, err := c.encode(msg)
 	if err != nil {
 		return err
 	}
 
-	closed, err := safeSend(ctx, c.sendCh, data)
-	if err != nil {
-		return err
-	}
-	if closed {
-		return ErrConnectionClosed
-	}
-	return nil
+	return c.sendQueue.push(ctx, Priority(msg.Priority), data, false)
+}
+
+// connParams are the parameters of the connections of a Serve or Client.
+type connParams struct {
+	handler      EventHandler
+	codec        Codec[*message.Message]
+	capabilities *Capabilities
+	signing      *messageSigning
+	config       ConnConfig
+	limiter      *concurrencyLimiter
+	// proxy URL dialed by the agent
+	endpoint string
+	// agent instance ID
+	instanceID string
+	// called when the connection reached the max age, drainConn if not set
+	expire func(*Conn) bool
+	// called when the peer drains the connection
+	onPeerDrain func(*Conn)
+	// called when the proxy asks the agent to reconnect
+	onGoAway func(conn *Conn, hintURL string)
+	// called when the proxy closed the connection replaced by another instance
+	onReplaced func(*Conn)
+	logger     *slog.Logger
 }
 
-func handleConn(parent context.Context, pool *Pool, clientID string, conn *websocket.Conn, handler EventHandler, codec Codec[*message.Message], logger *slog.Logger) *Conn {
+func handleConn(parent context.Context, pool *Pool, clientID string, labels map[string]string, conn *websocket.Conn, params connParams) *Conn {
 	ctx, cancel := context.WithCancel(parent)
 
-	const inFlightCount = 1024
-	sendCh := make(chan []byte, inFlightCount)
+	sendQueue := newSendQueue(params.config.InFlightCount, params.config.PriorityWeights)
 
 	client := &Conn{
-		pool:     pool,
-		clientID: clientID,
-		conn:     conn,
-		respMap:  util.NewSyncedMap[string, chan []byte](),
-		sendCh:   sendCh,
-		sendClose: sync.OnceFunc(func() {
-			close(sendCh)
-		}),
-		cancel:  cancel,
-		handler: handler,
-		codec:   codec,
-		logger:  logger,
+		pool:              pool,
+		clientID:          clientID,
+		labels:            labels,
+		endpoint:          params.endpoint,
+		instanceID:        params.instanceID,
+		conn:              conn,
+		respMap:           util.NewSyncedMap[string, chan *message.Message](),
+		sendQueue:         sendQueue,
+		sendClose:         sync.OnceFunc(sendQueue.close),
+		done:              make(chan struct{}),
+		cancel:            cancel,
+		handler:           params.handler,
+		codec:             withMaxMessageSize(params.codec, params.config.MaxMessageSize),
+		localCapabilities: connCapabilities(params.capabilities, params.codec),
+		signing:           params.signing,
+		config:            params.config,
+		limiter:           params.limiter,
+		pingPeriodCh:      make(chan time.Duration, 1),
+		onPeerDrain:       params.onPeerDrain,
+		onGoAway:          params.onGoAway,
+		onReplaced:        params.onReplaced,
+		logger:            params.logger,
 	}
+	client.touch()
+	client.capabilities.Store(legacyCapabilities(client.localCapabilities))
 	pool.register(client)
 
 	go client.writeLoop(ctx)
 	go client.readLoop(ctx)
-
-	return client
-}
-
-func safeSend[T any](ctx context.Context, ch chan T, value T) (closed bool, err error) {
-	defer func() {
-		if recover() != nil {
-			closed = true
+	if params.config.MaxAge > 0 || params.config.IdleTimeout > 0 {
+		expire := params.expire
+		if expire == nil {
+			expire = drainConn
 		}
-	}()
-
-	select {
-	case ch <- value:
-		return false, nil
-	case <-ctx.Done():
-		return false, ctx.Err()
+		go client.lifetimeLoop(ctx, expire)
 	}
+
+	return client
 }
@@ -0,0 +1,321 @@
+package ws
+
+import (
+	"bufio"
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"golang.org/x/net/proxy"
+)
+
+var ErrProxyConnect = errors.New("proxy connect failed")
+
+type netDialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+func (f netDialFunc) Dial(network, addr string) (net.Conn, error) {
+	return f(context.Background(), network, addr)
+}
+
+func (f netDialFunc) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	return f(ctx, network, addr)
+}
+
+// forwardDial returns the net dial function of the dialer, the outbound proxy is dialed with it.
+func forwardDial(dialer *websocket.Dialer) netDialFunc {
+	if dialer.NetDialContext != nil {
+		return dialer.NetDialContext
+	}
+	if dialer.NetDial != nil {
+		return func(_ context.Context, network, addr string) (net.Conn, error) {
+			return dialer.NetDial(network, addr)
+		}
+	}
+	return (&net.Dialer{}).DialContext
+}
+
+// proxyDialer dials the addresses through the outbound HTTP, HTTPS or SOCKS5 proxy.
+type proxyDialer struct {
+	proxyURL *url.URL
+	username string
+	password string
+	// TLS config of the HTTPS proxy, nil for the defaults
+	tlsConfig *tls.Config
+	forward   netDialFunc
+}
+
+// netDialContext returns the function dialing the proxy URL through the outbound proxy, nil if no proxy is used.
+// The outbound proxy is selected for the handshake request, so HTTPS_PROXY applies to the wss scheme.
+func (c *Client) netDialContext(urlStr string, forward netDialFunc) (netDialFunc, error) {
+	if c.dialProxy == nil {
+		return nil, nil
+	}
+	u, err := url.Parse(urlStr)
+	if err != nil {
+		return nil, err
+	}
+	target := *u
+	switch u.Scheme {
+	case "ws":
+		target.Scheme = "http"
+	case "wss":
+		target.Scheme = "https"
+	}
+	proxyURL, err := c.dialProxy(&http.Request{Method: http.MethodGet, URL: &target, Host: target.Host})
+	if err != nil || proxyURL == nil {
+		return nil, err
+	}
+	d := &proxyDialer{
+		proxyURL: proxyURL,
+		forward:  forward,
+	}
+	if proxyURL.User != nil {
+		d.username = proxyURL.User.Username()
+		d.password, _ = proxyURL.User.Password()
+	}
+	if c.dialProxyUsername != "" {
+		d.username, d.password = c.dialProxyUsername, c.dialProxyPassword
+	}
+	if c.dialProxyTLSConfigFunc != nil {
+		d.tlsConfig = c.dialProxyTLSConfigFunc()
+	}
+	return d.DialContext, nil
+}
+
+func (d *proxyDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	switch d.proxyURL.Scheme {
+	case "socks5", "socks5h":
+		var auth *proxy.Auth
+		if d.username != "" {
+			auth = &proxy.Auth{User: d.username, Password: d.password}
+		}
+		dialer, err := proxy.SOCKS5("tcp", proxyHostPort(d.proxyURL), auth, d.forward)
+		if err != nil {
+			return nil, err
+		}
+		return dialer.(proxy.ContextDialer).DialContext(ctx, network, addr)
+	case "http", "https":
+		return d.dialConnect(ctx, addr)
+	}
+	return nil, fmt.Errorf("%w: unsupported proxy scheme '%s'", ErrProxyConnect, d.proxyURL.Scheme)
+}
+
+// dialConnect opens the tunnel with the CONNECT request, the credentials are sent when the proxy requests them.
+func (d *proxyDialer) dialConnect(ctx context.Context, addr string) (net.Conn, error) {
+	conn, resp, err := d.connect(ctx, addr, "")
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusProxyAuthRequired && d.username != "" {
+		_ = conn.Close()
+		authorization, err := proxyAuthorization(resp.Header.Values("Proxy-Authenticate"), d.username, d.password, addr)
+		if err != nil {
+			return nil, err
+		}
+		if conn, resp, err = d.connect(ctx, addr, authorization); err != nil {
+			return nil, err
+		}
+	}
+	if resp.StatusCode != http.StatusOK {
+		_ = conn.Close()
+		return nil, fmt.Errorf("%w: %s", ErrProxyConnect, resp.Status)
+	}
+	return conn, nil
+}
+
+func (d *proxyDialer) connect(ctx context.Context, addr string, authorization string) (net.Conn, *http.Response, error) {
+	conn, err := d.forward(ctx, "tcp", proxyHostPort(d.proxyURL))
+	if err != nil {
+		return nil, nil, err
+	}
+	if d.proxyURL.Scheme == "https" {
+		tlsConfig := &tls.Config{}
+		if d.tlsConfig != nil {
+			tlsConfig = d.tlsConfig.Clone()
+		}
+		if tlsConfig.ServerName == "" {
+			tlsConfig.ServerName = d.proxyURL.Hostname()
+		}
+		tlsConn := tls.Client(conn, tlsConfig)
+		if err = tlsConn.HandshakeContext(ctx); err != nil {
+			_ = conn.Close()
+			return nil, nil, err
+		}
+		conn = tlsConn
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+		defer func() {
+			_ = conn.SetDeadline(time.Time{})
+		}()
+	}
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if authorization != "" {
+		req.Header.Set("Proxy-Authorization", authorization)
+	}
+	if err = req.Write(conn); err != nil {
+		_ = conn.Close()
+		return nil, nil, err
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		_ = conn.Close()
+		return nil, nil, err
+	}
+	_ = resp.Body.Close()
+	if br.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, reader: br}, resp, nil
+	}
+	return conn, resp, nil
+}
+
+func proxyHostPort(proxyURL *url.URL) string {
+	if port := proxyURL.Port(); port != "" {
+		return proxyURL.Host
+	}
+	switch proxyURL.Scheme {
+	case "https":
+		return net.JoinHostPort(proxyURL.Hostname(), "443")
+	case "socks5", "socks5h":
+		return net.JoinHostPort(proxyURL.Hostname(), "1080")
+	}
+	return net.JoinHostPort(proxyURL.Hostname(), "80")
+}
+
+// bufferedConn returns the data read ahead with the CONNECT response first.
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
+
+// proxyAuthorization answers the Digest challenge or the Basic one.
+func proxyAuthorization(challenges []string, username, password, uri string) (string, error) {
+	for _, challenge := range challenges {
+		if scheme, params := parseChallenge(challenge); strings.EqualFold(scheme, "Digest") {
+			cnonce := make([]byte, 16)
+			if _, err := rand.Read(cnonce); err != nil {
+				return "", err
+			}
+			return digestAuthorization(params, username, password, http.MethodConnect, uri, hex.EncodeToString(cnonce))
+		}
+	}
+	for _, challenge := range challenges {
+		if scheme, _ := parseChallenge(challenge); strings.EqualFold(scheme, "Basic") {
+			return "Basic " + base64.StdEncoding.EncodeToString([]byte(username+":"+password)), nil
+		}
+	}
+	return "", fmt.Errorf("%w: unsupported proxy authentication %q", ErrProxyConnect, challenges)
+}
+
+// parseChallenge returns the scheme and the parameters of the authentication challenge.
+func parseChallenge(challenge string) (string, map[string]string) {
+	scheme, rest, _ := strings.Cut(strings.TrimSpace(challenge), " ")
+	params := make(map[string]string)
+	for {
+		rest = strings.TrimLeft(rest, " ,")
+		key, value, ok := strings.Cut(rest, "=")
+		if !ok {
+			return scheme, params
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimLeft(value, " ")
+		if strings.HasPrefix(value, `"`) {
+			var sb strings.Builder
+			i := 1
+			for ; i < len(value) && value[i] != '"'; i++ {
+				if value[i] == '\\' && i+1 < len(value) {
+					i++
+				}
+				sb.WriteByte(value[i])
+			}
+			params[key] = sb.String()
+			rest = value[min(i+1, len(value)):]
+		} else {
+			token, tail, _ := strings.Cut(value, ",")
+			params[key] = strings.TrimSpace(token)
+			rest = tail
+		}
+	}
+}
+
+// digestAuthorization computes the RFC 7616 digest response with the MD5 or SHA-256 algorithm.
+func digestAuthorization(params map[string]string, username, password, method, uri, cnonce string) (string, error) {
+	algorithm := params["algorithm"]
+	if algorithm == "" {
+		algorithm = "MD5"
+	}
+	var newHash func() hash.Hash
+	switch strings.TrimSuffix(strings.ToUpper(algorithm), "-SESS") {
+	case "MD5":
+		newHash = md5.New
+	case "SHA-256":
+		newHash = sha256.New
+	default:
+		return "", fmt.Errorf("%w: unsupported digest algorithm '%s'", ErrProxyConnect, algorithm)
+	}
+	h := func(s string) string {
+		hh := newHash()
+		hh.Write([]byte(s))
+		return hex.EncodeToString(hh.Sum(nil))
+	}
+	realm, nonce := params["realm"], params["nonce"]
+	const nc = "00000001"
+	ha1 := h(username + ":" + realm + ":" + password)
+	if strings.HasSuffix(strings.ToUpper(algorithm), "-SESS") {
+		ha1 = h(ha1 + ":" + nonce + ":" + cnonce)
+	}
+	ha2 := h(method + ":" + uri)
+
+	var qop string
+	for _, value := range strings.Split(params["qop"], ",") {
+		if strings.TrimSpace(value) == "auth" {
+			qop = "auth"
+		}
+	}
+	var response string
+	if qop != "" {
+		response = h(ha1 + ":" + nonce + ":" + nc + ":" + cnonce + ":" + qop + ":" + ha2)
+	} else {
+		response = h(ha1 + ":" + nonce + ":" + ha2)
+	}
+	var sb strings.Builder
+	fmt.Fprintf(&sb, `Digest username="%s", realm="%s", nonce="%s", uri="%s", algorithm=%s, response="%s"`,
+		quoteEscape(username), quoteEscape(realm), quoteEscape(nonce), quoteEscape(uri), algorithm, response)
+	if qop != "" {
+		fmt.Fprintf(&sb, `, qop=%s, nc=%s, cnonce="%s"`, qop, nc, quoteEscape(cnonce))
+	}
+	if opaque, ok := params["opaque"]; ok {
+		fmt.Fprintf(&sb, `, opaque="%s"`, quoteEscape(opaque))
+	}
+	return sb.String(), nil
+}
+
+var quoteEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
+// quoteEscape escapes the value of a quoted string parameter.
+func quoteEscape(s string) string {
+	return quoteEscaper.Replace(s)
+}
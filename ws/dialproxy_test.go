@@ -0,0 +1,238 @@
+package ws
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/grepplabs/backstream/internal/message"
+	"github.com/stretchr/testify/require"
+)
+
+// connectProxy is an HTTP CONNECT proxy requesting the Basic or the Digest authentication.
+type connectProxy struct {
+	listener net.Listener
+	digest   bool
+	tunnels  atomic.Int32
+}
+
+// newConnectProxy starts the proxy, it is an HTTPS proxy if the TLS config is set.
+func newConnectProxy(t *testing.T, digest bool, tlsConfig *tls.Config) *connectProxy {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	if tlsConfig != nil {
+		listener = tls.NewListener(listener, tlsConfig)
+	}
+	p := &connectProxy{listener: listener, digest: digest}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go p.handle(conn)
+		}
+	}()
+	return p
+}
+
+func (p *connectProxy) handle(conn net.Conn) {
+	defer conn.Close()
+	req, err := http.ReadRequest(bufio.NewReader(conn))
+	if err != nil || req.Method != http.MethodConnect {
+		return
+	}
+	if !p.authorized(req) {
+		challenge := `Basic realm="proxy"`
+		if p.digest {
+			challenge = `Digest realm="proxy", nonce="4711", qop="auth", algorithm=SHA-256, opaque="x"`
+		}
+		_, _ = io.WriteString(conn, "HTTP/1.1 407 Proxy Authentication Required\r\nProxy-Authenticate: "+challenge+"\r\nContent-Length: 0\r\n\r\n")
+		return
+	}
+	target, err := net.Dial("tcp", req.Host)
+	if err != nil {
+		_, _ = io.WriteString(conn, "HTTP/1.1 502 Bad Gateway\r\n\r\n")
+		return
+	}
+	defer target.Close()
+	p.tunnels.Add(1)
+	_, _ = io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+	go func() {
+		_, _ = io.Copy(target, conn)
+	}()
+	_, _ = io.Copy(conn, target)
+}
+
+func (p *connectProxy) authorized(req *http.Request) bool {
+	authorization := req.Header.Get("Proxy-Authorization")
+	if !p.digest {
+		return authorization == "Basic dXNlcjpzZWNyZXQ="
+	}
+	scheme, params := parseChallenge(authorization)
+	if scheme != "Digest" {
+		return false
+	}
+	expected, err := digestAuthorization(map[string]string{"realm": "proxy", "nonce": "4711", "qop": "auth", "algorithm": "SHA-256", "opaque": "x"},
+		"user", "secret", http.MethodConnect, req.Host, params["cnonce"])
+	return err == nil && expected == authorization
+}
+
+func TestDigestAuthorization(t *testing.T) {
+	// RFC 2617 example
+	scheme, params := parseChallenge(`Digest realm="testrealm@host.com", qop="auth,auth-int", nonce="dcd98b7102dd2f0e8b11d0f600bfb0c093", opaque="5ccc069c403ebaf9f0171e9517f40e41"`)
+	require.Equal(t, "Digest", scheme)
+	require.Equal(t, "testrealm@host.com", params["realm"])
+	require.Equal(t, "auth,auth-int", params["qop"])
+
+	authorization, err := digestAuthorization(params, "Mufasa", "Circle Of Life", http.MethodGet, "/dir/index.html", "0a4f113b")
+	require.NoError(t, err)
+	_, result := parseChallenge(authorization)
+	require.Equal(t, "6629fae49393a05397450978507c4ef1", result["response"])
+	require.Equal(t, "5ccc069c403ebaf9f0171e9517f40e41", result["opaque"])
+
+	// quoted string values are escaped
+	params = map[string]string{"realm": `proxy "a\b"`, "nonce": "4711", "opaque": `"x"`}
+	authorization, err = digestAuthorization(params, `us"er`, "secret", http.MethodConnect, "example.com:443", "0a4f113b")
+	require.NoError(t, err)
+	_, result = parseChallenge(authorization)
+	require.Equal(t, `us"er`, result["username"])
+	require.Equal(t, `proxy "a\b"`, result["realm"])
+	require.Equal(t, `"x"`, result["opaque"])
+	require.Equal(t, "example.com:443", result["uri"])
+
+	_, err = digestAuthorization(map[string]string{"algorithm": "SHA-512-256"}, "Mufasa", "Circle Of Life", http.MethodGet, "/", "0a4f113b")
+	require.ErrorIs(t, err, ErrProxyConnect)
+}
+
+func TestClientDialProxy(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	codec := NewProtoCodec[*message.Message]()
+	serve := NewServe(ctx, &noopProxyHandler{}, codec)
+	server := httptest.NewServer(http.HandlerFunc(serve.HandleWS))
+	defer server.Close()
+	serverURL := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	for _, digest := range []bool{false, true} {
+		proxy := newConnectProxy(t, digest, nil)
+		proxyURL := &url.URL{Scheme: "http", Host: proxy.listener.Addr().String()}
+
+		_, err := NewClient(ctx, serverURL, &notifyRecorder{}, codec, WithClientID("4711"), WithClientDialProxyURL(proxyURL)).GetConn()
+		require.ErrorIs(t, err, ErrProxyConnect)
+
+		_, err = NewClient(ctx, serverURL, &notifyRecorder{}, codec, WithClientID("4711"), WithClientDialProxyURL(proxyURL),
+			WithClientDialProxyCredentials("user", "secret")).GetConn()
+		require.NoError(t, err)
+		require.Equal(t, int32(1), proxy.tunnels.Load())
+		_ = proxy.listener.Close()
+	}
+
+	// HTTPS proxy with the custom root CAs
+	tlsServer := httptest.NewTLSServer(http.NotFoundHandler())
+	defer tlsServer.Close()
+	proxy := newConnectProxy(t, false, &tls.Config{Certificates: tlsServer.TLS.Certificates})
+	defer proxy.listener.Close()
+	proxyURL := &url.URL{Scheme: "https", Host: proxy.listener.Addr().String()}
+	_, err := NewClient(ctx, serverURL, &notifyRecorder{}, codec, WithClientID("4711"), WithClientDialProxyURL(proxyURL),
+		WithClientDialProxyCredentials("user", "secret")).GetConn()
+	require.ErrorContains(t, err, "certificate")
+	rootCAs := x509.NewCertPool()
+	rootCAs.AddCert(tlsServer.Certificate())
+	_, err = NewClient(ctx, serverURL, &notifyRecorder{}, codec, WithClientID("4711"), WithClientDialProxyURL(proxyURL),
+		WithClientDialProxyCredentials("user", "secret"), WithClientDialProxyTLSConfigFunc(func() *tls.Config {
+			return &tls.Config{RootCAs: rootCAs}
+		})).GetConn()
+	require.NoError(t, err)
+	require.Equal(t, int32(1), proxy.tunnels.Load())
+
+	// SOCKS5 with the URL credentials
+	socks := newSocks5Proxy(t, "user", "secret")
+	defer socks.Close()
+	_, err = NewClient(ctx, serverURL, &notifyRecorder{}, codec, WithClientID("4712"),
+		WithClientDialProxyURL(&url.URL{Scheme: "socks5", Host: socks.Addr().String(), User: url.UserPassword("user", "secret")})).GetConn()
+	require.NoError(t, err)
+	require.NotNil(t, serve.GetConnByID("4712"))
+}
+
+// newSocks5Proxy serves the SOCKS5 CONNECT command with the username / password authentication.
+func newSocks5Proxy(t *testing.T, username, password string) net.Listener {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				// greeting: version, methods
+				header := make([]byte, 2)
+				if _, err := io.ReadFull(r, header); err != nil {
+					return
+				}
+				if _, err := io.ReadFull(r, make([]byte, header[1])); err != nil {
+					return
+				}
+				_, _ = conn.Write([]byte{5, 2})
+				// username / password: version, ulen, uname, plen, passwd
+				readString := func() string {
+					n, _ := r.ReadByte()
+					b := make([]byte, n)
+					_, _ = io.ReadFull(r, b)
+					return string(b)
+				}
+				_, _ = r.ReadByte()
+				if readString() != username || readString() != password {
+					_, _ = conn.Write([]byte{1, 1})
+					return
+				}
+				_, _ = conn.Write([]byte{1, 0})
+				// request: version, cmd, rsv, atyp, addr, port
+				request := make([]byte, 4)
+				if _, err := io.ReadFull(r, request); err != nil {
+					return
+				}
+				var host string
+				switch request[3] {
+				case 1:
+					ip := make([]byte, 4)
+					_, _ = io.ReadFull(r, ip)
+					host = net.IP(ip).String()
+				case 3:
+					host = readString()
+				default:
+					return
+				}
+				port := make([]byte, 2)
+				_, _ = io.ReadFull(r, port)
+				target, err := net.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))))
+				if err != nil {
+					_, _ = conn.Write([]byte{5, 1, 0, 1, 0, 0, 0, 0, 0, 0})
+					return
+				}
+				defer target.Close()
+				_, _ = conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+				go func() {
+					_, _ = io.Copy(target, r)
+				}()
+				_, _ = io.Copy(conn, target)
+			}()
+		}
+	}()
+	return listener
+}
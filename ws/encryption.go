@@ -0,0 +1,57 @@
+package ws
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+
+	"google.golang.org/protobuf/proto"
+)
+
+var ErrDecryptionFailed = errors.New("decryption failed")
+
+type encryptingCodec[T proto.Message] struct {
+	codec          Codec[T]
+	aead           cipher.AEAD
+	additionalData []byte
+}
+
+// NewEncryptingCodec seals the encoded values with the AEAD cipher, e.g. AES-GCM or XChaCha20-Poly1305.
+// The output is the random nonce followed by the ciphertext. The additional data are authenticated
+// but not encrypted, they bind the ciphertext to its purpose.
+func NewEncryptingCodec[T proto.Message](codec Codec[T], aead cipher.AEAD, additionalData []byte) Codec[T] {
+	return &encryptingCodec[T]{
+		codec:          codec,
+		aead:           aead,
+		additionalData: additionalData,
+	}
+}
+
+func (c *encryptingCodec[T]) Encode(v T) ([]byte, error) {
+	plaintext, err := c.codec.Encode(v)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, c.aead.NonceSize(), c.aead.NonceSize()+len(plaintext)+c.aead.Overhead())
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return c.aead.Seal(nonce, nonce, plaintext, c.additionalData), nil
+}
+
+func (c *encryptingCodec[T]) Decode(data []byte, t T) error {
+	nonceSize := c.aead.NonceSize()
+	if len(data) < nonceSize+c.aead.Overhead() {
+		return fmt.Errorf("%w: ciphertext too short", ErrDecryptionFailed)
+	}
+	plaintext, err := c.aead.Open(nil, data[:nonceSize], data[nonceSize:], c.additionalData)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrDecryptionFailed, err)
+	}
+	return c.codec.Decode(plaintext, t)
+}
+
+func (c *encryptingCodec[T]) IsBinary() bool {
+	return true
+}
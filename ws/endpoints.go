@@ -0,0 +1,211 @@
+package ws
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// SchemePrefixSRV marks the proxy URLs resolved by the DNS SRV lookup of the host,
+	// e.g. srv+wss://_backstream._tcp.example.com/ws
+	SchemePrefixSRV = "srv+"
+	// time an endpoint which failed is tried only after the healthy ones.
+	defaultEndpointCooldown = 30 * time.Second
+	// time the resolved SRV records are reused.
+	srvCacheTTL = 30 * time.Second
+)
+
+var ErrNoEndpoints = errors.New("no proxy endpoints")
+
+type srvEntry struct {
+	urls    []string
+	expires time.Time
+}
+
+// endpoints are the proxy URLs of a client together with their health.
+type endpoints struct {
+	mu       sync.Mutex
+	urls     []string
+	cooldown time.Duration
+	// endpoint URL to the time it is considered healthy again
+	unhealthy map[string]time.Time
+	srvCache  map[string]srvEntry
+	lookupSRV func(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+	logger    *slog.Logger
+}
+
+func newEndpoints(urls []string, cooldown time.Duration, logger *slog.Logger) *endpoints {
+	return &endpoints{
+		urls:      urls,
+		cooldown:  cooldown,
+		unhealthy: make(map[string]time.Time),
+		srvCache:  make(map[string]srvEntry),
+		lookupSRV: net.DefaultResolver.LookupSRV,
+		logger:    logger,
+	}
+}
+
+// resolve returns the proxy URLs with the SRV URLs expanded, the expansions keep the SRV priority order.
+func (e *endpoints) resolve(ctx context.Context) []string {
+	var result []string
+	for _, urlStr := range e.urls {
+		if !strings.HasPrefix(urlStr, SchemePrefixSRV) {
+			result = append(result, urlStr)
+			continue
+		}
+		urls, err := e.resolveSRV(ctx, urlStr)
+		if err != nil {
+			e.logger.Warn("proxy SRV lookup failed", slog.String("url", urlStr), slog.String("error", err.Error()))
+		}
+		result = append(result, urls...)
+	}
+	return result
+}
+
+// resolveSRV returns the URLs of the SRV targets, on lookup failure the expired URLs are returned with the error.
+func (e *endpoints) resolveSRV(ctx context.Context, urlStr string) ([]string, error) {
+	e.mu.Lock()
+	entry, ok := e.srvCache[urlStr]
+	e.mu.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		return entry.urls, nil
+	}
+
+	u, err := url.Parse(strings.TrimPrefix(urlStr, SchemePrefixSRV))
+	if err != nil {
+		return entry.urls, err
+	}
+	_, records, err := e.lookupSRV(ctx, "", "", u.Hostname())
+	if err != nil {
+		return entry.urls, err
+	}
+	urls := make([]string, 0, len(records))
+	for _, record := range records {
+		target := *u
+		target.Host = net.JoinHostPort(strings.TrimSuffix(record.Target, "."), strconv.Itoa(int(record.Port)))
+		urls = append(urls, target.String())
+	}
+	e.mu.Lock()
+	e.srvCache[urlStr] = srvEntry{urls: urls, expires: time.Now().Add(srvCacheTTL)}
+	e.mu.Unlock()
+	return urls, nil
+}
+
+// candidates returns the URLs in dial order: the healthy ones first, then the unhealthy ones which failed first.
+func (e *endpoints) candidates(ctx context.Context) []string {
+	urls := e.resolve(ctx)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	now := time.Now()
+	var healthy, unhealthy []string
+	for _, urlStr := range urls {
+		if until, ok := e.unhealthy[urlStr]; ok && now.Before(until) {
+			unhealthy = append(unhealthy, urlStr)
+		} else {
+			healthy = append(healthy, urlStr)
+		}
+	}
+	sort.SliceStable(unhealthy, func(i, j int) bool {
+		return e.unhealthy[unhealthy[i]].Before(e.unhealthy[unhealthy[j]])
+	})
+	return append(healthy, unhealthy...)
+}
+
+func (e *endpoints) healthy(urlStr string) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	until, ok := e.unhealthy[urlStr]
+	return !ok || time.Now().After(until)
+}
+
+func (e *endpoints) markUnhealthy(urlStr string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.unhealthy[urlStr] = time.Now().Add(e.cooldown)
+}
+
+func (e *endpoints) markHealthy(urlStr string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	delete(e.unhealthy, urlStr)
+}
+
+func (e *endpoints) unhealthyURLs() []string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	now := time.Now()
+	var result []string
+	for urlStr, until := range e.unhealthy {
+		if now.Before(until) {
+			result = append(result, urlStr)
+		}
+	}
+	slices.Sort(result)
+	return result
+}
+
+// UnhealthyEndpoints returns the proxy URLs which failed within the cooldown.
+func (c *Client) UnhealthyEndpoints() []string {
+	return c.endpoints.unhealthyURLs()
+}
+
+// connectAny dials the proxy endpoints in order until a connection is established.
+func (c *Client) connectAny() (*Conn, error) {
+	var errs []error
+	for _, urlStr := range c.endpoints.candidates(c.parent) {
+		conn, err := c.connect(urlStr)
+		if err == nil {
+			c.endpoints.markHealthy(urlStr)
+			return conn, nil
+		}
+		c.endpoints.markUnhealthy(urlStr)
+		c.logger.Warn("proxy dial failed", slog.String("url", urlStr), slog.String("error", err.Error()))
+		errs = append(errs, err)
+	}
+	switch len(errs) {
+	case 0:
+		return nil, ErrNoEndpoints
+	case 1:
+		return nil, errs[0]
+	}
+	return nil, errors.Join(errs...)
+}
+
+// connectAll dials the proxy endpoints without a connection, the unhealthy ones after the cooldown.
+func (c *Client) connectAll() error {
+	c.connectMu.Lock()
+	defer c.connectMu.Unlock()
+
+	if c.replaced.Load() {
+		return ErrInstanceReplaced
+	}
+	connected := make(map[string]bool)
+	for _, conn := range c.pool.GetConns(nil) {
+		if !conn.Draining() {
+			connected[conn.endpoint] = true
+		}
+	}
+	var errs []error
+	for _, urlStr := range c.endpoints.resolve(c.parent) {
+		if connected[urlStr] || !c.endpoints.healthy(urlStr) {
+			continue
+		}
+		if _, err := c.connect(urlStr); err != nil {
+			c.endpoints.markUnhealthy(urlStr)
+			errs = append(errs, err)
+			continue
+		}
+		c.endpoints.markHealthy(urlStr)
+	}
+	return errors.Join(errs...)
+}
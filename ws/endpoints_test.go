@@ -0,0 +1,110 @@
+package ws
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grepplabs/backstream/internal/message"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEndpointsCandidates(t *testing.T) {
+	e := newEndpoints([]string{"ws://a/ws", "srv+wss://_backstream._tcp.example.com/ws?x=1", "ws://b/ws"}, time.Minute, slog.Default())
+	lookups := 0
+	e.lookupSRV = func(_ context.Context, _, _, name string) (string, []*net.SRV, error) {
+		lookups++
+		require.Equal(t, "_backstream._tcp.example.com", name)
+		return "", []*net.SRV{
+			{Target: "proxy-1.example.com.", Port: 8443},
+			{Target: "proxy-2.example.com.", Port: 8443},
+		}, nil
+	}
+	require.Equal(t, []string{
+		"ws://a/ws",
+		"wss://proxy-1.example.com:8443/ws?x=1",
+		"wss://proxy-2.example.com:8443/ws?x=1",
+		"ws://b/ws",
+	}, e.candidates(context.Background()))
+
+	e.markUnhealthy("ws://a/ws")
+	e.markUnhealthy("wss://proxy-1.example.com:8443/ws?x=1")
+	require.Equal(t, []string{
+		"wss://proxy-2.example.com:8443/ws?x=1",
+		"ws://b/ws",
+		"ws://a/ws",
+		"wss://proxy-1.example.com:8443/ws?x=1",
+	}, e.candidates(context.Background()))
+	require.Equal(t, []string{"ws://a/ws", "wss://proxy-1.example.com:8443/ws?x=1"}, e.unhealthyURLs())
+	require.Equal(t, 1, lookups)
+
+	e.markHealthy("ws://a/ws")
+	require.True(t, e.healthy("ws://a/ws"))
+	require.Equal(t, "ws://a/ws", e.candidates(context.Background())[0])
+
+	// the expired records are used when the lookup fails
+	e.srvCache["srv+wss://_backstream._tcp.example.com/ws?x=1"] = srvEntry{urls: []string{"wss://proxy-3.example.com:8443/ws"}}
+	e.lookupSRV = func(context.Context, string, string, string) (string, []*net.SRV, error) {
+		return "", nil, errors.New("lookup failed")
+	}
+	require.Equal(t, []string{"ws://a/ws", "wss://proxy-3.example.com:8443/ws", "ws://b/ws"}, e.resolve(context.Background()))
+}
+
+func TestClientFailover(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	codec := NewProtoCodec[*message.Message]()
+	serve := NewServe(ctx, &noopProxyHandler{}, codec)
+	server := httptest.NewServer(http.HandlerFunc(serve.HandleWS))
+	defer server.Close()
+	down := httptest.NewServer(http.NotFoundHandler())
+	downURL := "ws" + strings.TrimPrefix(down.URL, "http")
+	down.Close()
+
+	client := NewClient(ctx, downURL, &notifyRecorder{}, codec, WithClientID("4711"),
+		WithClientProxyURLs("ws"+strings.TrimPrefix(server.URL, "http")))
+	_, err := client.GetConn()
+	require.NoError(t, err)
+	require.NotNil(t, serve.GetConnByID("4711"))
+	require.Equal(t, []string{downURL}, client.UnhealthyEndpoints())
+
+	client = NewClient(ctx, downURL, &notifyRecorder{}, codec, WithClientID("4712"))
+	_, err = client.GetConn()
+	require.Error(t, err)
+}
+
+func TestClientActiveActive(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	codec := NewProtoCodec[*message.Message]()
+	serve := NewServe(ctx, &noopProxyHandler{}, codec)
+	server := httptest.NewServer(http.HandlerFunc(serve.HandleWS))
+	defer server.Close()
+	otherServe := NewServe(ctx, &noopProxyHandler{}, codec)
+	otherServer := httptest.NewServer(http.HandlerFunc(otherServe.HandleWS))
+	defer otherServer.Close()
+
+	client := NewClient(ctx, "ws"+strings.TrimPrefix(server.URL, "http"), &notifyRecorder{}, codec, WithClientID("4711"),
+		WithClientProxyURLs("ws"+strings.TrimPrefix(otherServer.URL, "http")), WithClientActiveActive(true))
+	client.Start()
+	require.Eventually(t, func() bool {
+		return serve.GetConnByID("4711") != nil && otherServe.GetConnByID("4711") != nil
+	}, 3*time.Second, 10*time.Millisecond)
+
+	// the lost connection is dialed again
+	serve.GetConnByID("4711").Close()
+	require.Eventually(t, func() bool {
+		return serve.GetConnByID("4711") == nil
+	}, 3*time.Second, 10*time.Millisecond)
+	require.Eventually(t, func() bool {
+		return serve.GetConnByID("4711") != nil && client.pool.Size() == 2
+	}, 3*time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,127 @@
+package ws
+
+import (
+	"context"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	HeaderForwardSignature = "x-backstream-forward-signature"
+
+	forwardSignatureWindow = 30 * time.Second
+)
+
+type forwardedKey struct{}
+
+// unresolvedKey keys the request before the client ID resolution.
+type unresolvedKey struct{}
+
+// forwarded marks a request received from another replica.
+type forwarded struct {
+	replica string
+	// set if the request is signed with the cluster secret
+	trusted bool
+}
+
+// forwardedBy returns the replica which forwarded the request, empty if the request was not forwarded
+// or the forwarding replica was not authenticated.
+func forwardedBy(r *http.Request) string {
+	if f, ok := r.Context().Value(forwardedKey{}).(forwarded); ok && f.trusted {
+		return f.replica
+	}
+	return ""
+}
+
+// isForwarded reports whether the request was forwarded by another replica, authenticated or not.
+func isForwarded(r *http.Request) bool {
+	_, ok := r.Context().Value(forwardedKey{}).(forwarded)
+	return ok
+}
+
+// withUnresolved keeps the request before the client ID resolution, it is forwarded to the owner replica,
+// which resolves the client ID again.
+func withUnresolved(resolved *http.Request, r *http.Request) *http.Request {
+	if resolved == r {
+		return resolved
+	}
+	return resolved.WithContext(context.WithValue(resolved.Context(), unresolvedKey{}, r))
+}
+
+// unresolved returns the request to be forwarded, it is the request before the client ID resolution
+// with the body of the resolved one.
+func unresolved(r *http.Request) *http.Request {
+	original, ok := r.Context().Value(unresolvedKey{}).(*http.Request)
+	if !ok {
+		return r
+	}
+	result := original.WithContext(r.Context())
+	result.Body = r.Body
+	result.GetBody = r.GetBody
+	result.ContentLength = r.ContentLength
+	return result
+}
+
+// signForward marks the request as forwarded by this replica and signs it with the cluster secret if it is set.
+func (s *Serve) signForward(r *http.Request, clientID string) {
+	r.Header.Set(HeaderForwardedBy, s.advertiseURL)
+	r.Header.Del(HeaderForwardSignature)
+	if s.clusterSigner == nil {
+		return
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	signature, err := s.clusterSigner.Sign(forwardSignedData(s.advertiseURL, clientID, timestamp))
+	if err != nil {
+		s.logger.Error("forward signing failed", slog.String("error", err.Error()))
+		return
+	}
+	r.Header.Set(HeaderForwardSignature, timestamp+":"+hex.EncodeToString(signature))
+}
+
+// authenticateForward marks the requests forwarded by other replicas, they are not forwarded again.
+// The forwarding replica is trusted only if the request is signed with the cluster secret for the resolved client ID.
+// The forwarding headers are removed.
+func (s *Serve) authenticateForward(r *http.Request, clientID string) *http.Request {
+	replica := r.Header.Get(HeaderForwardedBy)
+	if replica == "" {
+		return r
+	}
+	trusted := false
+	if s.clusterSigner != nil {
+		if err := s.verifyForward(r, replica, clientID); err != nil {
+			s.logger.Warn("untrusted forwarded request", slog.String("forwarded-by", replica), slog.String("error", err.Error()))
+		} else {
+			trusted = true
+		}
+	}
+	r.Header.Del(HeaderForwardedBy)
+	r.Header.Del(HeaderForwardSignature)
+	return r.WithContext(context.WithValue(r.Context(), forwardedKey{}, forwarded{replica: replica, trusted: trusted}))
+}
+
+func (s *Serve) verifyForward(r *http.Request, replica string, clientID string) error {
+	timestamp, signature, ok := strings.Cut(r.Header.Get(HeaderForwardSignature), ":")
+	if !ok {
+		return ErrInvalidSignature
+	}
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	if age := time.Since(time.Unix(unix, 0)); age > forwardSignatureWindow || age < -forwardSignatureWindow {
+		return ErrMessageExpired
+	}
+	sig, err := hex.DecodeString(signature)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	return s.clusterSigner.Verify(forwardSignedData(replica, clientID, timestamp), sig)
+}
+
+func forwardSignedData(replica, clientID, timestamp string) []byte {
+	return []byte(replica + "\n" + clientID + "\n" + timestamp)
+}
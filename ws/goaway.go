@@ -0,0 +1,111 @@
+package ws
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/grepplabs/backstream/internal/message"
+	"google.golang.org/protobuf/proto"
+)
+
+var ErrInvalidHintURL = errors.New("invalid go away hint URL")
+
+// GoAway asks the agent to reconnect, to the hint URL if it is not empty. The agent dials the new connection first,
+// moves the new messages to it and drains this connection, so the in-flight requests are completed.
+// The connection is no longer selected for new messages, agents without the handshake are drained by the proxy.
+func (c *Conn) GoAway(hintURL string) error {
+	if c.capabilities.Load().Version == 0 {
+		c.drain()
+		return nil
+	}
+	data, err := proto.Marshal(&message.GoAway{Url: hintURL})
+	if err != nil {
+		return err
+	}
+	output, err := c.marshal(&message.Message{
+		Id:       uuid.New().String(),
+		Type:     message.Message_GOAWAY,
+		Data:     data,
+		Priority: int32(PriorityHigh),
+	})
+	if err != nil {
+		return err
+	}
+	c.draining.Store(true)
+	return c.sendQueue.push(context.Background(), PriorityHigh, output, false)
+}
+
+// GoAway asks the agents of the connections matched by the selector to reconnect, e.g. before the proxy is shut down.
+// A nil selector matches all connections.
+func (s *Serve) GoAway(selector ConnSelector, hintURL string) []NotifyResult {
+	conns := s.pool.GetConns(selector)
+	results := make([]NotifyResult, len(conns))
+	for i, conn := range conns {
+		results[i] = NotifyResult{
+			Conn: conn,
+			Err:  conn.GoAway(hintURL),
+		}
+	}
+	return results
+}
+
+// handleGoAway marks the connection as draining and lets the client replace it.
+func (c *Conn) handleGoAway(msg *message.Message) {
+	if c.onGoAway == nil {
+		c.logger.Warn("unexpected go away message", slog.String("id", msg.Id))
+		return
+	}
+	var goAway message.GoAway
+	if err := proto.Unmarshal(msg.Data, &goAway); err != nil {
+		c.logger.Error("invalid go away message", slog.String("error", err.Error()))
+		return
+	}
+	if c.draining.Swap(true) {
+		return
+	}
+	c.logger.Info("proxy asked to reconnect", slog.String("url", goAway.Url))
+	go c.onGoAway(c, goAway.Url)
+}
+
+// goAway replaces the connection on request of the proxy.
+// Without a valid hint URL, the other proxy URLs are preferred to the one going away.
+func (c *Client) goAway(conn *Conn, hintURL string) {
+	if hintURL != "" {
+		if err := c.checkHintURL(conn, hintURL); err != nil {
+			c.logger.Warn("go away hint URL ignored", slog.String("url", hintURL), slog.String("error", err.Error()))
+			hintURL = ""
+		}
+	}
+	if hintURL == "" {
+		c.endpoints.markUnhealthy(conn.endpoint)
+	}
+	c.replaceWithRetry(conn, hintURL)
+}
+
+// checkHintURL accepts the hint URL only with the ws or wss scheme, without downgrading wss to ws and only
+// for the hosts of the proxy URLs or the ones allowed by WithClientGoAwayURLs. The agent sends its credentials
+// to the hint URL.
+func (c *Client) checkHintURL(conn *Conn, hintURL string) error {
+	u, err := url.Parse(hintURL)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidHintURL, err)
+	}
+	if u.Scheme != "ws" && u.Scheme != "wss" {
+		return fmt.Errorf("%w: unsupported scheme '%s'", ErrInvalidHintURL, u.Scheme)
+	}
+	if u.Scheme == "ws" && strings.HasPrefix(conn.endpoint, "wss:") {
+		return fmt.Errorf("%w: insecure scheme", ErrInvalidHintURL)
+	}
+	allowed := append(c.endpoints.resolve(c.parent), c.goAwayURLs...)
+	for _, a := range allowed {
+		if matchOrigin(u, a) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: host '%s' is not allowed", ErrInvalidHintURL, u.Host)
+}
@@ -0,0 +1,109 @@
+package ws
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grepplabs/backstream/internal/message"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGoAway(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	codec := NewProtoCodec[*message.Message]()
+	serve := NewServe(ctx, &noopProxyHandler{}, codec)
+	server := httptest.NewServer(http.HandlerFunc(serve.HandleWS))
+	defer server.Close()
+	otherServe := NewServe(ctx, &noopProxyHandler{}, codec)
+	otherServer := httptest.NewServer(http.HandlerFunc(otherServe.HandleWS))
+	defer otherServer.Close()
+
+	agent := &blockingHandler{started: make(chan struct{}, 10), release: make(chan struct{})}
+	client := NewClient(ctx, "ws"+strings.TrimPrefix(server.URL, "http"), agent, codec, WithClientID("4711"),
+		WithClientGoAwayURLs("ws"+strings.TrimPrefix(otherServer.URL, "http")))
+	oldConn, err := client.GetConn()
+	require.NoError(t, err)
+	serverConn := serve.GetConnByID("4711")
+	require.NotNil(t, serverConn)
+
+	result := make(chan string, 1)
+	go func() {
+		output, err := serverConn.Send(ctx, []byte("in-flight"))
+		if err != nil {
+			result <- err.Error()
+			return
+		}
+		result <- string(output)
+	}()
+	<-agent.started
+
+	results := serve.GoAway(nil, "ws"+strings.TrimPrefix(otherServer.URL, "http"))
+	require.Len(t, results, 1)
+	require.NoError(t, results[0].Err)
+	require.True(t, serverConn.Draining())
+
+	// the agent migrates to the hint URL, the in-flight request is completed on the old connection
+	require.Eventually(t, func() bool {
+		return otherServe.GetConnByID("4711") != nil && oldConn.Draining()
+	}, 3*time.Second, 10*time.Millisecond)
+	newConn, err := client.GetConn()
+	require.NoError(t, err)
+	require.NotSame(t, oldConn, newConn)
+	require.Equal(t, 1, serve.pool.Size())
+
+	close(agent.release)
+	require.Equal(t, "in-flight", <-result)
+	require.Eventually(t, func() bool {
+		return serve.pool.Size() == 0
+	}, 3*time.Second, 10*time.Millisecond)
+
+	// without the hint the agent reconnects to the client URL
+	otherConn := otherServe.GetConnByID("4711")
+	results = otherServe.GoAway(nil, "")
+	require.Len(t, results, 1)
+	require.NoError(t, results[0].Err)
+	require.Eventually(t, func() bool {
+		return serve.GetConnByID("4711") != nil && otherServe.pool.Size() == 0
+	}, 3*time.Second, 10*time.Millisecond)
+	output, err := serve.GetConnByID("4711").Send(ctx, []byte("next"))
+	require.NoError(t, err)
+	require.Equal(t, "next", string(output))
+	require.True(t, otherConn.Draining())
+}
+
+func TestGoAwayHintURL(t *testing.T) {
+	client := NewClient(context.Background(), "wss://proxy-1.example.com/ws", &notifyRecorder{}, NewProtoCodec[*message.Message](),
+		WithClientProxyURLs("ws://10.0.0.1:8080/ws"), WithClientGoAwayURLs("wss://*.example.org"))
+	secureConn := &Conn{endpoint: "wss://proxy-1.example.com/ws"}
+	insecureConn := &Conn{endpoint: "ws://10.0.0.1:8080/ws"}
+	tests := []struct {
+		conn    *Conn
+		hintURL string
+		valid   bool
+	}{
+		{conn: secureConn, hintURL: "wss://proxy-1.example.com/other", valid: true},
+		{conn: secureConn, hintURL: "wss://proxy-2.example.org/ws", valid: true},
+		{conn: insecureConn, hintURL: "ws://10.0.0.1:8080/ws", valid: true},
+		{conn: insecureConn, hintURL: "wss://proxy-1.example.com/ws", valid: true},
+		{conn: secureConn, hintURL: "ws://10.0.0.1:8080/ws", valid: false},
+		{conn: secureConn, hintURL: "wss://evil.example.com/ws", valid: false},
+		{conn: secureConn, hintURL: "wss://example.org/ws", valid: false},
+		{conn: secureConn, hintURL: "https://proxy-1.example.com/ws", valid: false},
+		{conn: secureConn, hintURL: "wss://proxy-1.example.com:8443/ws", valid: false},
+		{conn: secureConn, hintURL: "://invalid", valid: false},
+	}
+	for _, tc := range tests {
+		err := client.checkHintURL(tc.conn, tc.hintURL)
+		if tc.valid {
+			require.NoError(t, err, tc.hintURL)
+		} else {
+			require.ErrorIs(t, err, ErrInvalidHintURL, tc.hintURL)
+		}
+	}
+}
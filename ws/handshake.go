@@ -0,0 +1,219 @@
+package ws
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/grepplabs/backstream/internal/message"
+	"google.golang.org/protobuf/proto"
+)
+
+// ProtocolVersion is the highest protocol version supported by this implementation.
+// Version 0 denotes a peer which does not perform the handshake.
+const ProtocolVersion uint32 = 1
+
+// FeatureCompression is offered by the connections with a compressing codec. The messages are compressed
+// only if both sides offer it, compressed messages are always accepted.
+const FeatureCompression = "compression"
+
+const defaultHandshakeTimeout = 5 * time.Second
+
+var ErrMessageTooLarge = errors.New("message too large")
+
+// Capabilities are exchanged in the HELLO / WELCOME handshake. After the handshake the connection
+// uses the common subset: the lower version and message size, and the features both sides support.
+type Capabilities struct {
+	Version        uint32
+	Codecs         []string
+	MaxMessageSize int64
+	Features       []string
+	PingPeriod     time.Duration
+}
+
+func (c *Capabilities) HasFeature(feature string) bool {
+	return slices.Contains(c.Features, feature)
+}
+
+func (c *Capabilities) clone() *Capabilities {
+	return &Capabilities{
+		Version:        c.Version,
+		Codecs:         slices.Clone(c.Codecs),
+		MaxMessageSize: c.MaxMessageSize,
+		Features:       slices.Clone(c.Features),
+		PingPeriod:     c.PingPeriod,
+	}
+}
+
+func (c *Capabilities) toHello() *message.Hello {
+	return &message.Hello{
+		Version:        c.Version,
+		Codecs:         c.Codecs,
+		MaxMessageSize: c.MaxMessageSize,
+		Features:       c.Features,
+		PingPeriod:     c.PingPeriod.Milliseconds(),
+	}
+}
+
+func capabilitiesFromHello(hello *message.Hello) *Capabilities {
+	return &Capabilities{
+		Version:        hello.Version,
+		Codecs:         hello.Codecs,
+		MaxMessageSize: hello.MaxMessageSize,
+		Features:       hello.Features,
+		PingPeriod:     time.Duration(hello.PingPeriod) * time.Millisecond,
+	}
+}
+
+func newCapabilities(config ConnConfig, features []string, codecs ...Codec[*message.Message]) *Capabilities {
+	var names []string
+	for _, codec := range codecs {
+		if name := codecName(codec); name != "" && !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	return &Capabilities{
+		Version:        ProtocolVersion,
+		Codecs:         names,
+		MaxMessageSize: config.MaxMessageSize,
+		Features:       features,
+		PingPeriod:     config.PingPeriod,
+	}
+}
+
+// connCapabilities offers the compression feature if and only if the connection codec decompresses messages.
+func connCapabilities(local *Capabilities, codec Codec[*message.Message]) *Capabilities {
+	_, compressing := codec.(*compressingCodec)
+	if compressing == local.HasFeature(FeatureCompression) {
+		return local
+	}
+	result := local.clone()
+	result.Features = slices.DeleteFunc(result.Features, func(feature string) bool {
+		return feature == FeatureCompression
+	})
+	if compressing {
+		result.Features = append(result.Features, FeatureCompression)
+	}
+	return result
+}
+
+// legacyCapabilities are assumed for peers which do not perform the handshake.
+func legacyCapabilities(local *Capabilities) *Capabilities {
+	return &Capabilities{
+		Version:        0,
+		Codecs:         slices.Clone(local.Codecs),
+		MaxMessageSize: local.MaxMessageSize,
+		PingPeriod:     local.PingPeriod,
+	}
+}
+
+func negotiateCapabilities(local *Capabilities, peer *Capabilities) *Capabilities {
+	result := &Capabilities{
+		Version:        min(local.Version, peer.Version),
+		MaxMessageSize: local.MaxMessageSize,
+	}
+	if peer.MaxMessageSize > 0 && peer.MaxMessageSize < result.MaxMessageSize {
+		result.MaxMessageSize = peer.MaxMessageSize
+	}
+	result.PingPeriod = local.PingPeriod
+	if peer.PingPeriod > 0 && peer.PingPeriod < result.PingPeriod {
+		result.PingPeriod = peer.PingPeriod
+	}
+	for _, codec := range local.Codecs {
+		if slices.Contains(peer.Codecs, codec) {
+			result.Codecs = append(result.Codecs, codec)
+		}
+	}
+	for _, feature := range local.Features {
+		if slices.Contains(peer.Features, feature) {
+			result.Features = append(result.Features, feature)
+		}
+	}
+	return result
+}
+
+// Capabilities returns the capabilities negotiated with the peer.
+func (c *Conn) Capabilities() *Capabilities {
+	return c.capabilities.Load().clone()
+}
+
+func (c *Conn) HasFeature(feature string) bool {
+	return c.capabilities.Load().HasFeature(feature)
+}
+
+// setCapabilities stores the negotiated capabilities and applies the negotiated ping period.
+func (c *Conn) setCapabilities(capabilities *Capabilities) {
+	c.capabilities.Store(capabilities)
+	if capabilities.PingPeriod > 0 && capabilities.PingPeriod < c.config.PingPeriod {
+		select {
+		case c.pingPeriodCh <- capabilities.PingPeriod:
+		default:
+		}
+	}
+}
+
+// hello sends the HELLO message and applies the capabilities negotiated by the peer.
+// On timeout the peer is assumed to be a legacy one, which ignores the HELLO message.
+func (c *Conn) hello(ctx context.Context, timeout time.Duration) error {
+	data, err := proto.Marshal(c.localCapabilities.toHello())
+	if err != nil {
+		return err
+	}
+	msg := &message.Message{
+		Id:       uuid.New().String(),
+		Type:     message.Message_HELLO,
+		Data:     data,
+		Priority: int32(PriorityHigh),
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	output, err := c.sendAndWait(ctx, msg)
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			c.logger.Warn("handshake timeout, peer does not support the handshake")
+			return nil
+		}
+		return fmt.Errorf("handshake failed: %w", err)
+	}
+	var welcome message.Hello
+	if err = proto.Unmarshal(output, &welcome); err != nil {
+		return fmt.Errorf("handshake failed: %w", err)
+	}
+	capabilities := capabilitiesFromHello(&welcome)
+	c.setCapabilities(capabilities)
+	c.logger.Debug("handshake completed", slog.Any("version", capabilities.Version), slog.Any("features", capabilities.Features))
+	return nil
+}
+
+// handleHello negotiates the capabilities with the peer and returns the WELCOME message.
+func (c *Conn) handleHello(msg *message.Message) []byte {
+	var hello message.Hello
+	if err := proto.Unmarshal(msg.Data, &hello); err != nil {
+		c.logger.Error("invalid hello message", slog.String("error", err.Error()))
+		return nil
+	}
+	capabilities := negotiateCapabilities(c.localCapabilities, capabilitiesFromHello(&hello))
+	data, err := proto.Marshal(capabilities.toHello())
+	if err != nil {
+		c.logger.Error(err.Error())
+		return nil
+	}
+	c.setCapabilities(capabilities)
+	c.logger.Debug("handshake completed", slog.Any("version", capabilities.Version), slog.Any("features", capabilities.Features))
+
+	output, err := c.marshal(&message.Message{
+		Id:   msg.Id,
+		Type: message.Message_WELCOME,
+		Data: data,
+	})
+	if err != nil {
+		c.logger.Error(err.Error())
+		return nil
+	}
+	return output
+}
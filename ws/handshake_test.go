@@ -0,0 +1,104 @@
+package ws
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/grepplabs/backstream/internal/message"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandshake(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	codec := NewProtoCodec[*message.Message]()
+	serve := NewServe(ctx, &noopProxyHandler{}, codec, WithServeFeatures("tracing", "cancel"))
+	server := httptest.NewServer(http.HandlerFunc(serve.HandleWS))
+	defer server.Close()
+
+	client := NewClient(ctx, "ws"+strings.TrimPrefix(server.URL, "http"), &notifyRecorder{}, codec, WithClientID("4711"), WithClientFeatures("streaming", "tracing"))
+	conn, err := client.GetConn()
+	require.NoError(t, err)
+
+	expected := &Capabilities{
+		Version:        ProtocolVersion,
+		Codecs:         []string{CodecNameProto},
+		MaxMessageSize: maxMessageSize,
+		Features:       []string{"tracing"},
+		PingPeriod:     defaultPingPeriod,
+	}
+	require.Equal(t, expected, conn.Capabilities())
+	require.True(t, conn.HasFeature("tracing"))
+	require.False(t, conn.HasFeature("streaming"))
+
+	serverConn := serve.GetConnByID("4711")
+	require.NotNil(t, serverConn)
+	require.Equal(t, expected, serverConn.Capabilities())
+}
+
+func TestHandshakeLegacyServe(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// proxy which does not respond to the HELLO message
+	upgrader := newUpgrader()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			if _, _, err = conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	defer server.Close()
+
+	codec := NewJsonCodec[*message.Message]()
+	client := NewClient(ctx, "ws"+strings.TrimPrefix(server.URL, "http"), &notifyRecorder{}, codec, WithClientFeatures("tracing"), WithClientHandshakeTimeout(100*time.Millisecond))
+	conn, err := client.GetConn()
+	require.NoError(t, err)
+	require.Equal(t, uint32(0), conn.Capabilities().Version)
+	require.False(t, conn.HasFeature("tracing"))
+}
+
+func TestHandshakeLegacyClient(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	codec := NewProtoCodec[*message.Message]()
+	serve := NewServe(ctx, &noopProxyHandler{}, codec, WithServeFeatures("tracing"))
+	server := httptest.NewServer(http.HandlerFunc(serve.HandleWS))
+	defer server.Close()
+
+	// agent which does not send the HELLO message
+	header := http.Header{HeaderClientId: {"4711"}}
+	conn, _, err := websocket.DefaultDialer.DialContext(ctx, "ws"+strings.TrimPrefix(server.URL, "http"), header)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	require.Eventually(t, func() bool {
+		return serve.GetConnByID("4711") != nil
+	}, 3*time.Second, 10*time.Millisecond)
+	serverConn := serve.GetConnByID("4711")
+	require.Equal(t, uint32(0), serverConn.Capabilities().Version)
+	require.False(t, serverConn.HasFeature("tracing"))
+}
+
+func TestMessageTooLarge(t *testing.T) {
+	conn := &Conn{codec: NewProtoCodec[*message.Message]()}
+	conn.capabilities.Store(&Capabilities{MaxMessageSize: 16})
+
+	_, err := conn.encode(&message.Message{Data: []byte("small")})
+	require.NoError(t, err)
+	_, err = conn.encode(&message.Message{Data: []byte("much larger than 16 bytes")})
+	require.ErrorIs(t, err, ErrMessageTooLarge)
+}
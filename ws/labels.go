@@ -0,0 +1,56 @@
+package ws
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	HeaderLabels   = "x-backstream-labels"
+	HeaderSelector = "x-backstream-selector"
+)
+
+// ParseLabels parses comma separated key=value pairs e.g. "region=eu,role=db".
+func ParseLabels(s string) (map[string]string, error) {
+	labels := make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(pair, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid label '%s', expected key=value", pair)
+		}
+		labels[key] = strings.TrimSpace(value)
+	}
+	return labels, nil
+}
+
+// FormatLabels formats labels as comma separated key=value pairs sorted by key.
+func FormatLabels(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		pairs = append(pairs, key+"="+labels[key])
+	}
+	return strings.Join(pairs, ",")
+}
+
+// LabelSelector selects connections having all the selector labels.
+func LabelSelector(selector map[string]string) ConnSelector {
+	return func(conn *Conn) bool {
+		for key, value := range selector {
+			if v, ok := conn.labels[key]; !ok || v != value {
+				return false
+			}
+		}
+		return true
+	}
+}
@@ -0,0 +1,32 @@
+package ws
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLabels(t *testing.T) {
+	labels, err := ParseLabels(" region=eu, role=db,empty=,")
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"region": "eu", "role": "db", "empty": ""}, labels)
+	require.Equal(t, "empty=,region=eu,role=db", FormatLabels(labels))
+
+	labels, err = ParseLabels("")
+	require.NoError(t, err)
+	require.Empty(t, labels)
+
+	_, err = ParseLabels("region")
+	require.Error(t, err)
+	_, err = ParseLabels("=eu")
+	require.Error(t, err)
+}
+
+func TestLabelSelector(t *testing.T) {
+	conn := &Conn{labels: map[string]string{"region": "eu", "role": "db"}}
+	require.True(t, LabelSelector(nil)(conn))
+	require.True(t, LabelSelector(map[string]string{"region": "eu"})(conn))
+	require.True(t, LabelSelector(map[string]string{"region": "eu", "role": "db"})(conn))
+	require.False(t, LabelSelector(map[string]string{"region": "us"})(conn))
+	require.False(t, LabelSelector(map[string]string{"region": "eu", "version": "1"})(conn))
+}
@@ -0,0 +1,225 @@
+package ws
+
+import (
+	"context"
+	"log/slog"
+	"math/rand"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/grepplabs/backstream/internal/message"
+)
+
+const (
+	// delay before the replacement of an expired connection is dialed again.
+	replaceRetryDelay = time.Second
+	// period of the in-flight checks of a draining connection.
+	drainCheckPeriod = 50 * time.Millisecond
+)
+
+// Draining reports whether the connection is draining. Draining connections are selected for new messages
+// only if no other connection is available.
+func (c *Conn) Draining() bool {
+	return c.draining.Load()
+}
+
+// touch records the message activity on the connection.
+func (c *Conn) touch() {
+	c.lastActive.Store(time.Now().UnixNano())
+}
+
+func (c *Conn) idleFor() time.Duration {
+	return time.Since(time.Unix(0, c.lastActive.Load()))
+}
+
+// busy reports whether messages are handled, awaited or queued.
+func (c *Conn) busy() bool {
+	return c.inFlight.Load() > 0 || c.sendQueue.len() > 0
+}
+
+func jitteredMaxAge(config ConnConfig) time.Duration {
+	if config.MaxAgeJitter <= 0 {
+		return config.MaxAge
+	}
+	return config.MaxAge + time.Duration(rand.Int63n(int64(config.MaxAgeJitter)))
+}
+
+// lifetimeLoop expires the connection after the max age and closes it after the idle timeout.
+// The expire function returns false if the connection could not be replaced, it is retried after a delay.
+func (c *Conn) lifetimeLoop(ctx context.Context, expire func(*Conn) bool) {
+	var ageTimer, idleTimer *time.Timer
+	var ageC, idleC <-chan time.Time
+	if c.config.MaxAge > 0 {
+		ageTimer = time.NewTimer(jitteredMaxAge(c.config))
+		defer ageTimer.Stop()
+		ageC = ageTimer.C
+	}
+	if c.config.IdleTimeout > 0 {
+		idleTimer = time.NewTimer(c.config.IdleTimeout)
+		defer idleTimer.Stop()
+		idleC = idleTimer.C
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ageC:
+			if c.Draining() {
+				// the peer is draining the connection
+				ageC = nil
+				continue
+			}
+			c.logger.Info("connection max age reached")
+			if !expire(c) {
+				ageTimer.Reset(replaceRetryDelay)
+			}
+		case <-idleC:
+			idle := c.idleFor()
+			switch {
+			case c.busy():
+				idleTimer.Reset(c.config.IdleTimeout)
+			case idle < c.config.IdleTimeout:
+				idleTimer.Reset(c.config.IdleTimeout - idle)
+			default:
+				c.logger.Info("closing idle connection", slog.String("idle", idle.String()))
+				c.Close()
+				return
+			}
+		}
+	}
+}
+
+// drainConn is the expire function of connections which are not replaced by this side.
+func drainConn(c *Conn) bool {
+	c.drain()
+	return true
+}
+
+// drain stops selecting the connection for new messages, asks the peer to do the same and closes
+// the connection when the in-flight messages are completed or the drain timeout elapsed.
+func (c *Conn) drain() {
+	c.drainOnce.Do(func() {
+		c.draining.Store(true)
+		c.sendDrain()
+		go func() {
+			timeout := time.NewTimer(c.config.DrainTimeout)
+			defer timeout.Stop()
+			ticker := time.NewTicker(drainCheckPeriod)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-c.done:
+					return
+				case <-timeout.C:
+					c.logger.Warn("drain timeout, closing connection with in-flight messages")
+					c.Close()
+					return
+				case <-ticker.C:
+					if !c.busy() {
+						c.logger.Info("connection drained")
+						c.Close()
+						return
+					}
+				}
+			}
+		}()
+	})
+}
+
+// sendDrain tells the peer that the connection is draining, legacy peers do not understand the message.
+func (c *Conn) sendDrain() {
+	if c.capabilities.Load().Version == 0 {
+		return
+	}
+	data, err := c.marshal(&message.Message{
+		Id:       uuid.New().String(),
+		Type:     message.Message_DRAIN,
+		Priority: int32(PriorityHigh),
+	})
+	if err != nil {
+		c.logger.Error(err.Error())
+		return
+	}
+	if err = c.sendQueue.push(context.Background(), PriorityHigh, data, false); err != nil {
+		c.logger.Warn("drain message send failure", slog.String("error", err.Error()))
+	}
+}
+
+// handleDrain marks the connection drained by the peer as draining.
+func (c *Conn) handleDrain() {
+	if c.draining.Swap(true) {
+		return
+	}
+	c.logger.Info("peer is draining the connection")
+	if c.onPeerDrain != nil {
+		go c.onPeerDrain(c)
+	}
+}
+
+// replace dials the replacement of the connection before draining it.
+// The preferred URL is tried first, if it is empty or fails the proxy URLs are tried in order.
+func (c *Client) replace(old *Conn, preferredURL string) bool {
+	c.connectMu.Lock()
+	defer c.connectMu.Unlock()
+
+	c.logger.Info("replacing connection")
+	if preferredURL != "" {
+		_, err := c.connect(preferredURL)
+		if err == nil {
+			old.drain()
+			return true
+		}
+		c.logger.Warn("replacement dial of preferred URL failed", slog.String("url", preferredURL), slog.String("error", err.Error()))
+	}
+	if _, err := c.connectAny(); err != nil {
+		c.logger.Warn("replacement dial failed", slog.String("error", err.Error()))
+		return false
+	}
+	old.drain()
+	return true
+}
+
+// sameEndpoint returns the proxy URL of the connection if it is healthy.
+func (c *Client) sameEndpoint(conn *Conn) string {
+	if c.endpoints.healthy(conn.endpoint) {
+		return conn.endpoint
+	}
+	return ""
+}
+
+// expire replaces the connection which reached the max age.
+func (c *Client) expire(conn *Conn) bool {
+	return c.replace(conn, c.sameEndpoint(conn))
+}
+
+// replaceDrained replaces the connection drained by the proxy.
+func (c *Client) replaceDrained(conn *Conn) {
+	c.replaceWithRetry(conn, c.sameEndpoint(conn))
+}
+
+// replaceWithRetry replaces the connection until it succeeds or the connection is closed.
+func (c *Client) replaceWithRetry(conn *Conn, hintURL string) {
+	for !c.replace(conn, hintURL) {
+		select {
+		case <-c.parent.Done():
+			return
+		case <-conn.done:
+			return
+		case <-time.After(replaceRetryDelay):
+		}
+	}
+}
+
+// preferActive returns the connections which are not draining, or all connections if every one is draining.
+func preferActive(conns []*Conn) []*Conn {
+	var active []*Conn
+	for _, conn := range conns {
+		if !conn.Draining() {
+			active = append(active, conn)
+		}
+	}
+	if len(active) == 0 {
+		return conns
+	}
+	return active
+}
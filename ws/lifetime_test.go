@@ -0,0 +1,164 @@
+package ws
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grepplabs/backstream/internal/message"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientMaxAge(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	codec := NewProtoCodec[*message.Message]()
+	serve := NewServe(ctx, &noopProxyHandler{}, codec)
+	server := httptest.NewServer(http.HandlerFunc(serve.HandleWS))
+	defer server.Close()
+
+	agent := &blockingHandler{started: make(chan struct{}, 10), release: make(chan struct{})}
+	client := NewClient(ctx, "ws"+strings.TrimPrefix(server.URL, "http"), agent, codec, WithClientID("4711"),
+		WithClientConnConfig(ConnConfig{MaxAge: 300 * time.Millisecond, MaxAgeJitter: 100 * time.Millisecond}))
+	oldConn, err := client.GetConn()
+	require.NoError(t, err)
+	oldServerConn := serve.GetConnByID("4711")
+	require.NotNil(t, oldServerConn)
+
+	// the in-flight request is completed on the draining connection
+	result := make(chan string, 1)
+	go func() {
+		output, err := oldServerConn.Send(ctx, []byte("in-flight"))
+		if err != nil {
+			result <- err.Error()
+			return
+		}
+		result <- string(output)
+	}()
+	<-agent.started
+
+	require.Eventually(t, func() bool {
+		return oldServerConn.Draining() && serve.pool.Size() == 2
+	}, 3*time.Second, 10*time.Millisecond)
+	require.True(t, oldConn.Draining())
+	newConn, err := client.GetConn()
+	require.NoError(t, err)
+	require.NotSame(t, oldConn, newConn)
+	require.NotSame(t, oldServerConn, serve.GetConnByID("4711"))
+
+	close(agent.release)
+	require.Equal(t, "in-flight", <-result)
+	require.Eventually(t, func() bool {
+		return serve.pool.Size() == 1
+	}, 3*time.Second, 10*time.Millisecond)
+	output, err := serve.GetConnByID("4711").Send(ctx, []byte("next"))
+	require.NoError(t, err)
+	require.Equal(t, "next", string(output))
+}
+
+func TestServeMaxAge(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	codec := NewProtoCodec[*message.Message]()
+	serve := NewServe(ctx, &noopProxyHandler{}, codec, WithServeConnConfig(ConnConfig{MaxAge: 300 * time.Millisecond}))
+	server := httptest.NewServer(http.HandlerFunc(serve.HandleWS))
+	defer server.Close()
+
+	client := NewClient(ctx, "ws"+strings.TrimPrefix(server.URL, "http"), &notifyRecorder{}, codec, WithClientID("4711"))
+	oldConn, err := client.GetConn()
+	require.NoError(t, err)
+
+	// the agent replaces the connection drained by the proxy
+	require.Eventually(t, func() bool {
+		conn := serve.GetConnByID("4711")
+		return oldConn.Draining() && conn != nil && !conn.Draining()
+	}, 3*time.Second, 10*time.Millisecond)
+	newConn, err := client.GetConn()
+	require.NoError(t, err)
+	require.NotSame(t, oldConn, newConn)
+	require.Eventually(t, func() bool {
+		select {
+		case <-oldConn.done:
+			return true
+		default:
+			return false
+		}
+	}, 3*time.Second, 10*time.Millisecond)
+}
+
+func TestIdleTimeout(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	codec := NewProtoCodec[*message.Message]()
+	serve := NewServe(ctx, &noopProxyHandler{}, codec, WithServeConnConfig(ConnConfig{IdleTimeout: 300 * time.Millisecond}))
+	server := httptest.NewServer(http.HandlerFunc(serve.HandleWS))
+	defer server.Close()
+
+	agent := &notifyRecorder{}
+	client := NewClient(ctx, "ws"+strings.TrimPrefix(server.URL, "http"), agent, codec, WithClientID("4711"))
+	_, err := client.GetConn()
+	require.NoError(t, err)
+	conn := serve.GetConnByID("4711")
+	require.NotNil(t, conn)
+
+	// messages keep the connection open
+	for i := 0; i < 5; i++ {
+		time.Sleep(100 * time.Millisecond)
+		require.NoError(t, conn.Notify(ctx, []byte("active")))
+	}
+	require.Same(t, conn, serve.GetConnByID("4711"))
+
+	// pings do not
+	require.Eventually(t, func() bool {
+		return serve.GetConnByID("4711") == nil
+	}, 3*time.Second, 10*time.Millisecond)
+}
+
+func TestIdleTimeoutAgent(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	codec := NewProtoCodec[*message.Message]()
+	serve := NewServe(ctx, &noopProxyHandler{}, codec)
+	server := httptest.NewServer(http.HandlerFunc(serve.HandleWS))
+	defer server.Close()
+
+	// the agent does not close idle connections
+	client := NewClient(ctx, "ws"+strings.TrimPrefix(server.URL, "http"), &notifyRecorder{}, codec, WithClientID("4711"), WithClientConnConfig(ConnConfig{IdleTimeout: 100 * time.Millisecond}))
+	require.Zero(t, client.connConfig.IdleTimeout)
+	conn, err := client.GetConn()
+	require.NoError(t, err)
+	time.Sleep(300 * time.Millisecond)
+	select {
+	case <-conn.done:
+		require.Fail(t, "idle connection closed")
+	default:
+	}
+	current, err := client.GetConn()
+	require.NoError(t, err)
+	require.Same(t, conn, current)
+}
+
+func TestPoolPreferActive(t *testing.T) {
+	pool := NewPool()
+	newConn := func(clientID string, draining bool) *Conn {
+		pool.seq++
+		conn := &Conn{seq: pool.seq}
+		conn.draining.Store(draining)
+		pool.clients[conn] = clientID
+		return conn
+	}
+	activeA := newConn("a", false)
+	newConn("a", true)
+	drainingB := newConn("b", true)
+
+	// draining connections are skipped only for client IDs with an active connection
+	require.Equal(t, []*Conn{activeA, drainingB}, pool.GetConns(nil))
+	require.Equal(t, []*Conn{drainingB}, pool.GetConnsByID("b"))
+}
@@ -0,0 +1,70 @@
+package ws
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+var ErrOverloaded = errors.New("overloaded")
+
+// PeerError is returned when the peer responds with the ERROR message, the code is an HTTP status code.
+type PeerError struct {
+	Code    int
+	Message string
+}
+
+func (e *PeerError) Error() string {
+	return fmt.Sprintf("peer error %d: %s", e.Code, e.Message)
+}
+
+func (e *PeerError) Is(target error) bool {
+	return target == ErrOverloaded && e.Code == http.StatusServiceUnavailable
+}
+
+// concurrencyLimiter limits the number of concurrently handled messages.
+// Messages exceeding the limit wait in the queue, messages exceeding the queue are rejected.
+type concurrencyLimiter struct {
+	admitted chan struct{}
+	workers  chan struct{}
+}
+
+// newConcurrencyLimiter returns nil (no limit) if workers is not positive, a negative queue size is taken as zero.
+func newConcurrencyLimiter(workers int, queueSize int) *concurrencyLimiter {
+	if workers <= 0 {
+		return nil
+	}
+	queueSize = max(queueSize, 0)
+	return &concurrencyLimiter{
+		admitted: make(chan struct{}, workers+queueSize),
+		workers:  make(chan struct{}, workers),
+	}
+}
+
+// admit takes a queue slot without blocking, it returns false if the queue is full.
+func (l *concurrencyLimiter) admit() bool {
+	select {
+	case l.admitted <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// acquire waits for a free worker of the admitted message, the queue slot is given up if the context is done.
+func (l *concurrencyLimiter) acquire(ctx context.Context) bool {
+	select {
+	case l.workers <- struct{}{}:
+		return true
+	case <-ctx.Done():
+		<-l.admitted
+		return false
+	}
+}
+
+// release frees the worker and the queue slot.
+func (l *concurrencyLimiter) release() {
+	<-l.workers
+	<-l.admitted
+}
@@ -0,0 +1,124 @@
+package ws
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/grepplabs/backstream/internal/message"
+	"github.com/stretchr/testify/require"
+)
+
+type blockingHandler struct {
+	notifyRecorder
+	started chan struct{}
+	release chan struct{}
+}
+
+func (h *blockingHandler) HandleRequest(_ context.Context, event []byte) ([]byte, error) {
+	h.started <- struct{}{}
+	<-h.release
+	return event, nil
+}
+
+func TestConcurrencyLimit(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	codec := NewProtoCodec[*message.Message]()
+	serve := NewServe(ctx, &noopProxyHandler{}, codec)
+	server := httptest.NewServer(http.HandlerFunc(serve.HandleWS))
+	defer server.Close()
+
+	agent := &blockingHandler{started: make(chan struct{}, 10), release: make(chan struct{})}
+	client := NewClient(ctx, "ws"+strings.TrimPrefix(server.URL, "http"), agent, codec, WithClientID("4711"), WithClientConcurrencyLimit(1, 1))
+	_, err := client.GetConn()
+	require.NoError(t, err)
+	conn := serve.GetConnByID("4711")
+	require.NotNil(t, conn)
+
+	var wg sync.WaitGroup
+	results := make([]string, 2)
+	send := func(i int) {
+		defer wg.Done()
+		output, err := conn.Send(ctx, []byte{byte('a' + i)})
+		if err == nil {
+			results[i] = string(output)
+		}
+	}
+	wg.Add(1)
+	go send(0)
+	<-agent.started
+	wg.Add(1)
+	go send(1)
+
+	// the second request is queued, the third one is rejected
+	require.Eventually(t, func() bool {
+		_, err := conn.Send(ctx, []byte("c"))
+		return errors.Is(err, ErrOverloaded)
+	}, 3*time.Second, 10*time.Millisecond)
+	var peerErr *PeerError
+	_, err = conn.Send(ctx, []byte("c"))
+	require.ErrorAs(t, err, &peerErr)
+	require.Equal(t, http.StatusServiceUnavailable, peerErr.Code)
+
+	close(agent.release)
+	wg.Wait()
+	require.Equal(t, []string{"a", "b"}, results)
+
+	output, err := conn.Send(ctx, []byte("d"))
+	require.NoError(t, err)
+	require.Equal(t, "d", string(output))
+}
+
+func TestConcurrencyLimiterAdmit(t *testing.T) {
+	l := newConcurrencyLimiter(1, 1)
+	require.True(t, l.admit())
+	require.True(t, l.admit())
+	require.False(t, l.admit())
+
+	require.True(t, l.acquire(context.Background()))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	// the queued message gives up its slot
+	require.False(t, l.acquire(ctx))
+	require.True(t, l.admit())
+	require.False(t, l.admit())
+
+	l.release()
+	require.True(t, l.admit())
+
+	// non-positive workers disable the limit, a negative queue is empty
+	require.Nil(t, newConcurrencyLimiter(0, 1))
+	require.Nil(t, newConcurrencyLimiter(-1, 1))
+	l = newConcurrencyLimiter(1, -1)
+	require.True(t, l.admit())
+	require.False(t, l.admit())
+}
+
+func TestWriteProxyError(t *testing.T) {
+	serve := NewServe(context.Background(), &noopProxyHandler{}, NewProtoCodec[*message.Message]())
+	tests := []struct {
+		err  error
+		code int
+	}{
+		{err: &PeerError{Code: http.StatusServiceUnavailable}, code: http.StatusServiceUnavailable},
+		{err: &PeerError{Code: http.StatusNotFound}, code: http.StatusNotFound},
+		{err: &PeerError{Code: http.StatusOK}, code: http.StatusBadGateway},
+		{err: &PeerError{Code: 0}, code: http.StatusBadGateway},
+		{err: &PeerError{Code: 1000}, code: http.StatusBadGateway},
+		{err: &PeerError{Code: -1}, code: http.StatusBadGateway},
+		{err: ErrQueueFull, code: http.StatusServiceUnavailable},
+		{err: ErrConnectionClosed, code: http.StatusBadGateway},
+	}
+	for _, tc := range tests {
+		w := httptest.NewRecorder()
+		serve.writeProxyError(w, tc.err)
+		require.Equal(t, tc.code, w.Code, tc.err.Error())
+	}
+}
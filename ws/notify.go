@@ -0,0 +1,52 @@
+package ws
+
+import (
+	"context"
+	"sync"
+)
+
+// ConnSelector selects connections for group operations.
+type ConnSelector func(conn *Conn) bool
+
+// ClientIDSelector selects connections of any of the client IDs.
+func ClientIDSelector(clientIDs ...string) ConnSelector {
+	ids := make(map[string]struct{}, len(clientIDs))
+	for _, id := range clientIDs {
+		ids[id] = struct{}{}
+	}
+	return func(conn *Conn) bool {
+		_, ok := ids[conn.clientID]
+		return ok
+	}
+}
+
+type NotifyResult struct {
+	Conn *Conn
+	// Err is nil if the notification was sent to the connection.
+	Err error
+}
+
+// Broadcast sends the notification to all connections.
+func (s *Serve) Broadcast(ctx context.Context, data []byte) []NotifyResult {
+	return s.NotifyGroup(ctx, nil, data)
+}
+
+// NotifyGroup concurrently sends the notification to all connections matched by the selector.
+func (s *Serve) NotifyGroup(ctx context.Context, selector ConnSelector, data []byte) []NotifyResult {
+	conns := s.pool.GetConns(selector)
+	results := make([]NotifyResult, len(conns))
+
+	var wg sync.WaitGroup
+	for i, conn := range conns {
+		wg.Add(1)
+		go func(i int, conn *Conn) {
+			defer wg.Done()
+			results[i] = NotifyResult{
+				Conn: conn,
+				Err:  conn.Notify(ctx, data),
+			}
+		}(i, conn)
+	}
+	wg.Wait()
+	return results
+}
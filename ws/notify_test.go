@@ -0,0 +1,55 @@
+package ws
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grepplabs/backstream/internal/message"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNotifyGroup(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	codec := NewProtoCodec[*message.Message]()
+	serve := NewServe(ctx, &noopProxyHandler{}, codec)
+	server := httptest.NewServer(http.HandlerFunc(serve.HandleWS))
+	defer server.Close()
+
+	agents := map[string]*notifyRecorder{
+		"4711": {},
+		"4712": {},
+		"4713": {},
+	}
+	for clientID, agent := range agents {
+		client := NewClient(ctx, "ws"+strings.TrimPrefix(server.URL, "http"), agent, codec, WithClientID(clientID))
+		_, err := client.GetConn()
+		require.NoError(t, err)
+	}
+	require.Eventually(t, func() bool {
+		return serve.pool.Size() == len(agents)
+	}, 3*time.Second, 10*time.Millisecond)
+
+	results := serve.NotifyGroup(ctx, ClientIDSelector("4711", "4713"), []byte("group"))
+	require.Len(t, results, 2)
+	for _, result := range results {
+		require.NoError(t, result.Err)
+		require.Contains(t, []string{"4711", "4713"}, result.Conn.ClientID())
+	}
+
+	results = serve.Broadcast(ctx, []byte("all"))
+	require.Len(t, results, 3)
+	for _, result := range results {
+		require.NoError(t, result.Err)
+	}
+
+	require.Eventually(t, func() bool {
+		return len(agents["4711"].Received()) == 2 && len(agents["4712"].Received()) == 1 && len(agents["4713"].Received()) == 2
+	}, 3*time.Second, 10*time.Millisecond)
+	require.Equal(t, []string{"all"}, agents["4712"].Received())
+}
@@ -0,0 +1,236 @@
+package ws
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/grepplabs/backstream/outbox"
+)
+
+const (
+	outboxAckTimeout         = 10 * time.Second
+	outboxRetryDelay         = 5 * time.Second
+	defaultOutboxMaxAttempts = 10
+)
+
+var ErrOutboxNotConfigured = errors.New("outbox is not configured")
+
+// WithServeOutbox enables store-and-forward notifications, see Serve.NotifyDurable.
+func WithServeOutbox(store outbox.Store) ServeOption {
+	return func(s *Serve) {
+		s.outbox = &outboxDelivery{
+			store:      store,
+			running:    make(map[string]bool),
+			attempts:   make(map[string]map[string]int),
+			retryDelay: outboxRetryDelay,
+		}
+	}
+}
+
+// WithServeOutboxDeadLetter sets how many times the delivery of an outbox entry is attempted, zero means forever.
+// Entries rejected by the agent or not acknowledged in time that often are removed from the outbox and passed
+// to the dead letter function, which can be nil. By default, the delivery is attempted 10 times.
+func WithServeOutboxDeadLetter(maxAttempts int, deadLetter func(OutboxDeadLetter)) ServeOption {
+	return func(s *Serve) {
+		s.outboxMaxAttempts = maxAttempts
+		s.outboxDeadLetter = deadLetter
+	}
+}
+
+// OutboxDeadLetter is an outbox entry which could not be delivered.
+type OutboxDeadLetter struct {
+	ClientID string
+	Entry    outbox.Entry
+	Attempts int
+	// Err is the last delivery error, a *PeerError if the agent rejected the entry
+	// or context.DeadlineExceeded if the agent did not acknowledge it in time.
+	Err error
+}
+
+type outboxDelivery struct {
+	store      outbox.Store
+	retryDelay time.Duration
+
+	mu sync.Mutex
+	// client IDs with a running delivery, true if another delivery round was requested meanwhile.
+	running map[string]bool
+	// failed delivery attempts by the client ID and the entry ID.
+	attempts map[string]map[string]int
+}
+
+// NotifyDurable queues the notification in the outbox and delivers it when an agent with clientID is connected.
+// Notifications are delivered in order and removed from the outbox after they were acknowledged by the agent.
+// The ttl limits how long the notification is kept, zero means forever.
+// The delivery is at-least-once: the agent drops the redeliveries it remembers in memory only, so a notification
+// can be handled again after the agent restarted. Agents deduplicate by NotificationIDFromContext if needed.
+func (s *Serve) NotifyDurable(ctx context.Context, clientID string, data []byte, ttl time.Duration) error {
+	if s.outbox == nil {
+		return ErrOutboxNotConfigured
+	}
+	entry := outbox.Entry{
+		ID:   uuid.New().String(),
+		Data: data,
+	}
+	if ttl > 0 {
+		entry.Expires = time.Now().Add(ttl)
+	}
+	if err := s.outbox.store.Append(ctx, clientID, entry); err != nil {
+		return err
+	}
+	s.deliverOutbox(clientID)
+	return nil
+}
+
+type notificationIDKey struct{}
+
+// NotificationIDFromContext returns the ID of the durable notification handled with the context, see Serve.NotifyDurable.
+// The ID is the same for all deliveries of the notification, it is empty for other notifications.
+func NotificationIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(notificationIDKey{}).(string)
+	return id
+}
+
+// deliverOutbox starts the delivery for the client ID unless it is already running.
+func (s *Serve) deliverOutbox(clientID string) {
+	d := s.outbox
+	d.mu.Lock()
+	if _, ok := d.running[clientID]; ok {
+		d.running[clientID] = true
+		d.mu.Unlock()
+		return
+	}
+	d.running[clientID] = false
+	d.mu.Unlock()
+
+	go func() {
+		for {
+			retry := s.deliverOutboxEntries(clientID)
+
+			d.mu.Lock()
+			again := d.running[clientID]
+			if !again {
+				delete(d.running, clientID)
+			} else {
+				d.running[clientID] = false
+			}
+			d.mu.Unlock()
+
+			if again {
+				continue
+			}
+			if retry {
+				time.AfterFunc(d.retryDelay, func() {
+					if s.parent.Err() == nil && s.GetConnByID(clientID) != nil {
+						s.deliverOutbox(clientID)
+					}
+				})
+			}
+			return
+		}
+	}()
+}
+
+// deliverOutboxEntries delivers queued entries and reports whether the delivery should be retried.
+func (s *Serve) deliverOutboxEntries(clientID string) bool {
+	conn := s.GetConnByID(clientID)
+	if conn == nil {
+		return false
+	}
+	logger := conn.logger
+	entries, err := s.outbox.store.List(s.parent, clientID)
+	if err != nil {
+		logger.Error("outbox list failed", slog.String("error", err.Error()))
+		return true
+	}
+	s.outbox.pruneAttempts(clientID, entries)
+	for _, entry := range entries {
+		ctx, cancel := context.WithTimeout(s.parent, outboxAckTimeout)
+		deliveryErr := conn.notifyAck(ctx, entry.ID, entry.Data)
+		cancel()
+		if deliveryErr != nil {
+			var peerErr *PeerError
+			switch {
+			case errors.As(deliveryErr, &peerErr):
+				logger.Warn("outbox entry rejected", slog.String("id", entry.ID), slog.String("error", deliveryErr.Error()))
+			case errors.Is(deliveryErr, context.DeadlineExceeded):
+				logger.Warn("outbox entry not acknowledged", slog.String("id", entry.ID), slog.String("error", deliveryErr.Error()))
+			default:
+				// the entry is not to blame, e.g. the connection was closed
+				logger.Warn("outbox delivery failed", slog.String("id", entry.ID), slog.String("error", deliveryErr.Error()))
+				return true
+			}
+			attempts := s.outbox.failed(clientID, entry.ID)
+			if s.outboxMaxAttempts <= 0 || attempts < s.outboxMaxAttempts {
+				return true
+			}
+			// move the entry out of the way of the following ones
+			logger.Error("outbox entry dead lettered", slog.String("id", entry.ID), slog.Int("attempts", attempts), slog.String("error", deliveryErr.Error()))
+			if err = s.outbox.store.Ack(s.parent, clientID, entry.ID); err != nil {
+				logger.Error("outbox ack failed", slog.String("id", entry.ID), slog.String("error", err.Error()))
+				return true
+			}
+			s.outbox.forget(clientID, entry.ID)
+			if s.outboxDeadLetter != nil {
+				s.outboxDeadLetter(OutboxDeadLetter{ClientID: clientID, Entry: entry, Attempts: attempts, Err: deliveryErr})
+			}
+			continue
+		}
+		if err = s.outbox.store.Ack(s.parent, clientID, entry.ID); err != nil {
+			// the entry will be redelivered and dropped by the agent as a duplicate, unless it restarted meanwhile
+			logger.Error("outbox ack failed", slog.String("id", entry.ID), slog.String("error", err.Error()))
+			return true
+		}
+		s.outbox.forget(clientID, entry.ID)
+		logger.Debug("outbox entry delivered", slog.String("id", entry.ID))
+	}
+	return false
+}
+
+// failed counts the failed delivery attempt of the entry and returns the number of failed attempts.
+func (d *outboxDelivery) failed(clientID string, id string) int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.attempts[clientID] == nil {
+		d.attempts[clientID] = make(map[string]int)
+	}
+	d.attempts[clientID][id]++
+	return d.attempts[clientID][id]
+}
+
+func (d *outboxDelivery) forget(clientID string, id string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	delete(d.attempts[clientID], id)
+	if len(d.attempts[clientID]) == 0 {
+		delete(d.attempts, clientID)
+	}
+}
+
+// pruneAttempts forgets the attempts of the entries which are no longer queued, e.g. the expired ones.
+func (d *outboxDelivery) pruneAttempts(clientID string, entries []outbox.Entry) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	attempts := d.attempts[clientID]
+	if attempts == nil {
+		return
+	}
+	queued := make(map[string]struct{}, len(entries))
+	for _, entry := range entries {
+		queued[entry.ID] = struct{}{}
+	}
+	for id := range attempts {
+		if _, ok := queued[id]; !ok {
+			delete(attempts, id)
+		}
+	}
+	if len(attempts) == 0 {
+		delete(d.attempts, clientID)
+	}
+}
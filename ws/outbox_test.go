@@ -0,0 +1,159 @@
+package ws
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/grepplabs/backstream/internal/message"
+	"github.com/grepplabs/backstream/outbox"
+	"github.com/stretchr/testify/require"
+)
+
+type notifyRecorder struct {
+	mu       sync.Mutex
+	received []string
+	ids      []string
+}
+
+func (h *notifyRecorder) HandleRequest(_ context.Context, _ []byte) ([]byte, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (h *notifyRecorder) HandleNotify(ctx context.Context, event []byte) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.received = append(h.received, string(event))
+	h.ids = append(h.ids, NotificationIDFromContext(ctx))
+	return nil
+}
+
+func (h *notifyRecorder) Received() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]string(nil), h.received...)
+}
+
+type noopProxyHandler struct {
+	notifyRecorder
+}
+
+func (h *noopProxyHandler) ProxyRequest(_ *Conn, _ http.ResponseWriter, _ *http.Request) error {
+	return errors.New("not implemented")
+}
+
+func TestNotifyDurable(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	codec := NewProtoCodec[*message.Message]()
+	store := outbox.NewMemoryStore()
+	serve := NewServe(ctx, &noopProxyHandler{}, codec, WithServeOutbox(store))
+	server := httptest.NewServer(http.HandlerFunc(serve.HandleWS))
+	defer server.Close()
+
+	require.NoError(t, serve.NotifyDurable(ctx, "4711", []byte("one"), 0))
+	require.NoError(t, serve.NotifyDurable(ctx, "4711", []byte("two"), time.Minute))
+	require.NoError(t, serve.NotifyDurable(ctx, "4711", []byte("expired"), time.Nanosecond))
+	require.NoError(t, serve.NotifyDurable(ctx, "4712", []byte("other"), 0))
+
+	agent := &notifyRecorder{}
+	client := NewClient(ctx, "ws"+strings.TrimPrefix(server.URL, "http"), agent, codec, WithClientID("4711"))
+	_, err := client.GetConn()
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		return len(agent.Received()) == 2
+	}, 3*time.Second, 10*time.Millisecond)
+
+	require.NoError(t, serve.NotifyDurable(ctx, "4711", []byte("three"), 0))
+	require.Eventually(t, func() bool {
+		entries, err := store.List(ctx, "4711")
+		return err == nil && len(entries) == 0
+	}, 3*time.Second, 10*time.Millisecond)
+	require.Equal(t, []string{"one", "two", "three"}, agent.Received())
+
+	entries, err := store.List(ctx, "4712")
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+
+	// redelivery of an already acknowledged notification is acknowledged but not handled
+	conn := serve.GetConnByID("4711")
+	require.NotNil(t, conn)
+	sendCtx, sendCancel := context.WithTimeout(ctx, 3*time.Second)
+	defer sendCancel()
+	require.NoError(t, conn.notifyAck(sendCtx, "4711-4", []byte("four")))
+	require.NoError(t, conn.notifyAck(sendCtx, "4711-4", []byte("four")))
+	require.Equal(t, []string{"one", "two", "three", "four"}, agent.Received())
+
+	// the agent can deduplicate by the notification ID, other notifications have none
+	require.NoError(t, conn.Notify(sendCtx, []byte("five")))
+	require.Eventually(t, func() bool {
+		return len(agent.Received()) == 5
+	}, 3*time.Second, 10*time.Millisecond)
+	agent.mu.Lock()
+	defer agent.mu.Unlock()
+	require.Len(t, agent.ids, 5)
+	for _, id := range agent.ids[:3] {
+		require.NotEmpty(t, id)
+	}
+	require.Equal(t, "4711-4", agent.ids[3])
+	require.Empty(t, agent.ids[4])
+}
+
+type failingNotifyHandler struct {
+	notifyRecorder
+}
+
+func (h *failingNotifyHandler) HandleNotify(ctx context.Context, event []byte) error {
+	if string(event) == "poison" {
+		return errors.New("cannot handle")
+	}
+	return h.notifyRecorder.HandleNotify(ctx, event)
+}
+
+func TestNotifyDurableDeadLetter(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	codec := NewProtoCodec[*message.Message]()
+	store := outbox.NewMemoryStore()
+	deadLetters := make(chan OutboxDeadLetter, 1)
+	serve := NewServe(ctx, &noopProxyHandler{}, codec, WithServeOutbox(store), WithServeOutboxDeadLetter(2, func(deadLetter OutboxDeadLetter) {
+		deadLetters <- deadLetter
+	}))
+	serve.outbox.retryDelay = 10 * time.Millisecond
+	server := httptest.NewServer(http.HandlerFunc(serve.HandleWS))
+	defer server.Close()
+
+	require.NoError(t, serve.NotifyDurable(ctx, "4711", []byte("poison"), 0))
+	require.NoError(t, serve.NotifyDurable(ctx, "4711", []byte("next"), 0))
+
+	agent := &failingNotifyHandler{}
+	client := NewClient(ctx, "ws"+strings.TrimPrefix(server.URL, "http"), agent, codec, WithClientID("4711"))
+	_, err := client.GetConn()
+	require.NoError(t, err)
+
+	select {
+	case deadLetter := <-deadLetters:
+		require.Equal(t, "4711", deadLetter.ClientID)
+		require.Equal(t, "poison", string(deadLetter.Entry.Data))
+		require.Equal(t, 2, deadLetter.Attempts)
+		// the agent rejected the entry, no timeout
+		var peerErr *PeerError
+		require.ErrorAs(t, deadLetter.Err, &peerErr)
+		require.Contains(t, peerErr.Message, "cannot handle")
+	case <-time.After(3 * time.Second):
+		t.Fatal("entry was not dead lettered")
+	}
+	require.Eventually(t, func() bool {
+		entries, err := store.List(ctx, "4711")
+		return err == nil && len(entries) == 0
+	}, 3*time.Second, 10*time.Millisecond)
+	require.Equal(t, []string{"next"}, agent.Received())
+}
@@ -1,41 +1,75 @@
 package ws
 
 import (
+	"sort"
 	"sync"
+
+	"github.com/grepplabs/backstream/internal/util"
 )
 
+// number of acknowledged notification IDs remembered to drop redeliveries.
+const ackedNotifyCount = 4096
+
 type Pool struct {
 	mu sync.Mutex
+	// serializes register / unregister together with their callbacks per client ID.
+	hookMu *util.KeyedMutex[string]
 	// registered clients.
 	clients map[*Conn]string
+	// client IDs reserved by the connections being upgraded.
+	reservations map[*reservation]struct{}
+	// registration sequence, gives connections a stable order.
+	seq uint64
+	// acknowledged notification IDs, they outlive the connections.
+	acked *util.RecentSet[string]
+	// closed and replaced when a connection is registered.
+	registeredCh chan struct{}
+	// called with the number of connections for the client ID after conn was registered.
+	onRegister func(conn *Conn, count int)
+	// called with the number of connections for the client ID after conn was unregistered.
+	onUnregister func(conn *Conn, count int)
 }
 
 func NewPool() *Pool {
 	return &Pool{
-		clients: make(map[*Conn]string),
+		clients:      make(map[*Conn]string),
+		hookMu:       util.NewKeyedMutex[string](),
+		reservations: make(map[*reservation]struct{}),
+		acked:        util.NewRecentSet[string](ackedNotifyCount),
+		registeredCh: make(chan struct{}),
 	}
 }
 
+// GetConn returns a connection, draining connections are returned only if no other is available.
 func (m *Pool) GetConn() *Conn {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
+	var draining *Conn
 	for client := range m.clients {
-		return client
+		if !client.Draining() {
+			return client
+		}
+		draining = client
 	}
-	return nil
+	return draining
 }
 
+// GetConnByID returns a connection of the client ID, draining connections are returned only if no other is available.
 func (m *Pool) GetConnByID(id string) *Conn {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
+	var draining *Conn
 	for client, clientId := range m.clients {
 		if clientId == id {
-			return client
+			if !client.Draining() {
+				return client
+			}
+			draining = client
 		}
 	}
-	return nil
+	return draining
 }
 
 func (m *Pool) GetConnsByID(id string) (result []*Conn) {
@@ -47,6 +81,26 @@ func (m *Pool) GetConnsByID(id string) (result []*Conn) {
 			result = append(result, client)
 		}
 	}
+	sortConns(result)
+	return preferActive(result)
+}
+
+// GetConns returns all connections matched by the selector, nil selector matches all connections.
+// Draining connections of a client ID are returned only if no other connection of the client ID is matched.
+func (m *Pool) GetConns(selector ConnSelector) (result []*Conn) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	byID := make(map[string][]*Conn)
+	for client, clientId := range m.clients {
+		if selector == nil || selector(client) {
+			byID[clientId] = append(byID[clientId], client)
+		}
+	}
+	for _, conns := range byID {
+		result = append(result, preferActive(conns)...)
+	}
+	sortConns(result)
 	return result
 }
 
@@ -56,14 +110,149 @@ func (m *Pool) Size() int {
 	return len(m.clients)
 }
 
+func (m *Pool) clientIDs() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	seen := make(map[string]struct{})
+	result := make([]string, 0, len(m.clients))
+	for _, clientId := range m.clients {
+		if _, ok := seen[clientId]; !ok {
+			seen[clientId] = struct{}{}
+			result = append(result, clientId)
+		}
+	}
+	return result
+}
+
+// reservation holds the client ID for an agent instance until its connection is registered.
+type reservation struct {
+	clientID   string
+	instanceID string
+}
+
+// reserve reserves the client ID for the agent instance if accept returns true, otherwise it returns nil.
+// accept is called with the instance IDs of the registered and reserved connections of the client ID,
+// the check and the reservation are atomic. accept must not call the pool.
+func (m *Pool) reserve(clientID string, instanceID string, accept func(instanceIDs []string) bool) *reservation {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var conns []*Conn
+	for client, clientId := range m.clients {
+		if clientId == clientID {
+			conns = append(conns, client)
+		}
+	}
+	sortConns(conns)
+	instanceIDs := make([]string, 0, len(conns))
+	for _, conn := range conns {
+		instanceIDs = append(instanceIDs, conn.instanceID)
+	}
+	for res := range m.reservations {
+		if res.clientID == clientID {
+			instanceIDs = append(instanceIDs, res.instanceID)
+		}
+	}
+	if !accept(instanceIDs) {
+		return nil
+	}
+	res := &reservation{
+		clientID:   clientID,
+		instanceID: instanceID,
+	}
+	m.reservations[res] = struct{}{}
+	return res
+}
+
+// release removes the reservation, nil is ignored.
+func (m *Pool) release(res *reservation) {
+	if res == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.reservations, res)
+}
+
+// olderInstances returns the connections of the client ID registered before conn by other agent instances.
+// Connections without the instance ID cannot be told apart, they are all other instances.
+func (m *Pool) olderInstances(conn *Conn) (result []*Conn) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for client, clientId := range m.clients {
+		if clientId == conn.clientID && client.seq < conn.seq && (conn.instanceID == "" || client.instanceID != conn.instanceID) {
+			result = append(result, client)
+		}
+	}
+	sortConns(result)
+	return result
+}
+
+// withRegistered calls fn if the client ID has registered connections. Registrations of the client ID are blocked
+// while fn runs, so fn does not race with the register and unregister callbacks.
+func (m *Pool) withRegistered(id string, fn func()) {
+	defer m.hookMu.Lock(id)()
+
+	m.mu.Lock()
+	count := m.countLocked(id)
+	m.mu.Unlock()
+
+	if count != 0 {
+		fn()
+	}
+}
+
 func (m *Pool) register(conn *Conn) {
+	defer m.hookMu.Lock(conn.clientID)()
+
 	m.mu.Lock()
+	m.seq++
+	conn.seq = m.seq
 	m.clients[conn] = conn.clientID
+	count := m.countLocked(conn.clientID)
+	close(m.registeredCh)
+	m.registeredCh = make(chan struct{})
 	m.mu.Unlock()
+
+	if m.onRegister != nil {
+		m.onRegister(conn, count)
+	}
+}
+
+// registered returns a channel which is closed when the next connection is registered.
+func (m *Pool) registered() <-chan struct{} {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.registeredCh
 }
 
 func (m *Pool) unregister(conn *Conn) {
+	defer m.hookMu.Lock(conn.clientID)()
+
 	m.mu.Lock()
+	_, ok := m.clients[conn]
 	delete(m.clients, conn)
+	count := m.countLocked(conn.clientID)
 	m.mu.Unlock()
+
+	if ok && m.onUnregister != nil {
+		m.onUnregister(conn, count)
+	}
+}
+
+func (m *Pool) countLocked(id string) (count int) {
+	for _, clientId := range m.clients {
+		if clientId == id {
+			count++
+		}
+	}
+	return count
+}
+
+func sortConns(conns []*Conn) {
+	sort.Slice(conns, func(i, j int) bool {
+		return conns[i].seq < conns[j].seq
+	})
 }
@@ -0,0 +1,200 @@
+package ws
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const HeaderPriority = "x-backstream-priority"
+
+var ErrQueueFull = errors.New("send queue full")
+
+// Priority is the send priority of a message, the responses inherit the priority of the requests.
+type Priority int32
+
+const (
+	PriorityNormal Priority = iota
+	PriorityHigh
+	PriorityLow
+	numPriorities
+)
+
+// order in which the scheduler visits the queues within a round.
+var priorityOrder = [numPriorities]Priority{PriorityHigh, PriorityNormal, PriorityLow}
+
+func defaultPriorityWeights() map[Priority]int {
+	return map[Priority]int{
+		PriorityHigh:   4,
+		PriorityNormal: 2,
+		PriorityLow:    1,
+	}
+}
+
+func (p Priority) String() string {
+	switch p {
+	case PriorityNormal:
+		return "normal"
+	case PriorityHigh:
+		return "high"
+	case PriorityLow:
+		return "low"
+	}
+	return fmt.Sprintf("Priority(%d)", int32(p))
+}
+
+func (p Priority) valid() bool {
+	return p >= 0 && p < numPriorities
+}
+
+func ParsePriority(value string) (Priority, error) {
+	for p := Priority(0); p < numPriorities; p++ {
+		if strings.EqualFold(value, p.String()) {
+			return p, nil
+		}
+	}
+	return PriorityNormal, fmt.Errorf("unknown priority '%s'", value)
+}
+
+type priorityKey struct{}
+
+// WithPriority returns the context which sets the priority of the messages sent with it.
+func WithPriority(ctx context.Context, priority Priority) context.Context {
+	return context.WithValue(ctx, priorityKey{}, priority)
+}
+
+func PriorityFromContext(ctx context.Context) Priority {
+	if priority, ok := ctx.Value(priorityKey{}).(Priority); ok && priority.valid() {
+		return priority
+	}
+	return PriorityNormal
+}
+
+// PriorityRule returns the priority of the proxy request, false if the rule does not apply.
+type PriorityRule func(r *http.Request) (Priority, bool)
+
+// NewPathPrefixPriorityRule applies the priority to requests with the path prefix.
+func NewPathPrefixPriorityRule(prefix string, priority Priority) PriorityRule {
+	return func(r *http.Request) (Priority, bool) {
+		return priority, strings.HasPrefix(r.URL.Path, prefix)
+	}
+}
+
+// requestPriority returns the priority from the header, the first matching rule or the normal priority.
+func (s *Serve) requestPriority(r *http.Request) (Priority, error) {
+	if value := r.Header.Get(HeaderPriority); value != "" {
+		priority, err := ParsePriority(value)
+		if err != nil {
+			return PriorityNormal, fmt.Errorf("header %s is invalid: %w", HeaderPriority, err)
+		}
+		return priority, nil
+	}
+	for _, rule := range s.priorityRules {
+		if priority, ok := rule(r); ok {
+			return priority, nil
+		}
+	}
+	return PriorityNormal, nil
+}
+
+// withRequestPriority sets the request priority in the request context.
+func (s *Serve) withRequestPriority(r *http.Request) (*http.Request, error) {
+	priority, err := s.requestPriority(r)
+	if err != nil {
+		return nil, err
+	}
+	if priority == PriorityNormal {
+		return r, nil
+	}
+	return r.WithContext(WithPriority(r.Context(), priority)), nil
+}
+
+// sendQueue holds the outbound messages in bounded queues per priority.
+// The queues are drained by a weighted round-robin, in every round a priority can send up to its weight of messages.
+type sendQueue struct {
+	queues  [numPriorities]chan []byte
+	weights [numPriorities]int
+	credits [numPriorities]int
+	// signals the writer that a message was queued.
+	ready  chan struct{}
+	closed chan struct{}
+}
+
+func newSendQueue(size int, weights map[Priority]int) *sendQueue {
+	q := &sendQueue{
+		ready:  make(chan struct{}, 1),
+		closed: make(chan struct{}),
+	}
+	for p := Priority(0); p < numPriorities; p++ {
+		q.queues[p] = make(chan []byte, size)
+		q.weights[p] = weights[p]
+	}
+	q.credits = q.weights
+	return q
+}
+
+// push queues the message, if the queue is full it waits when wait is set or fails with ErrQueueFull.
+func (q *sendQueue) push(ctx context.Context, priority Priority, data []byte, wait bool) error {
+	select {
+	case <-q.closed:
+		return ErrConnectionClosed
+	default:
+	}
+	if !priority.valid() {
+		priority = PriorityNormal
+	}
+	if wait {
+		select {
+		case q.queues[priority] <- data:
+		case <-q.closed:
+			return ErrConnectionClosed
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	} else {
+		select {
+		case q.queues[priority] <- data:
+		default:
+			return fmt.Errorf("%w: %s priority", ErrQueueFull, priority)
+		}
+	}
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// next returns the next message to be written, it must be called by the writer only.
+func (q *sendQueue) next() ([]byte, bool) {
+	for round := 0; round < 2; round++ {
+		for _, p := range priorityOrder {
+			if q.credits[p] <= 0 {
+				continue
+			}
+			select {
+			case data := <-q.queues[p]:
+				q.credits[p]--
+				return data, true
+			default:
+			}
+		}
+		// no queue with credits has messages, start a new round
+		q.credits = q.weights
+	}
+	return nil, false
+}
+
+// len returns the number of queued messages.
+func (q *sendQueue) len() (n int) {
+	for _, queue := range q.queues {
+		n += len(queue)
+	}
+	return n
+}
+
+func (q *sendQueue) close() {
+	close(q.closed)
+}
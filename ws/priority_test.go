@@ -0,0 +1,140 @@
+package ws
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/grepplabs/backstream/internal/message"
+	"github.com/stretchr/testify/require"
+)
+
+type priorityHandler struct {
+	notifyRecorder
+}
+
+func (h *priorityHandler) HandleRequest(ctx context.Context, _ []byte) ([]byte, error) {
+	return []byte(PriorityFromContext(ctx).String()), nil
+}
+
+type priorityProxyHandler struct {
+	notifyRecorder
+}
+
+func (h *priorityProxyHandler) ProxyRequest(conn *Conn, w http.ResponseWriter, r *http.Request) error {
+	output, err := conn.Send(r.Context(), nil)
+	if err != nil {
+		return err
+	}
+	_, _ = w.Write(output)
+	return nil
+}
+
+func TestSendQueueWeightedOrder(t *testing.T) {
+	ctx := context.Background()
+	q := newSendQueue(10, map[Priority]int{PriorityHigh: 2, PriorityNormal: 1, PriorityLow: 1})
+	for i := 0; i < 4; i++ {
+		require.NoError(t, q.push(ctx, PriorityLow, []byte("l"), false))
+		require.NoError(t, q.push(ctx, PriorityNormal, []byte("n"), false))
+		require.NoError(t, q.push(ctx, PriorityHigh, []byte("h"), false))
+	}
+	var order string
+	for {
+		data, ok := q.next()
+		if !ok {
+			break
+		}
+		order += string(data)
+	}
+	require.Equal(t, "hhnlhhnlnlnl", order)
+}
+
+func TestSendQueueFull(t *testing.T) {
+	ctx := context.Background()
+	q := newSendQueue(1, defaultPriorityWeights())
+	require.NoError(t, q.push(ctx, PriorityLow, []byte("a"), false))
+	err := q.push(ctx, PriorityLow, []byte("b"), false)
+	require.True(t, errors.Is(err, ErrQueueFull))
+	// other priorities are not affected
+	require.NoError(t, q.push(ctx, PriorityHigh, []byte("c"), false))
+
+	waitCtx, cancel := context.WithCancel(ctx)
+	cancel()
+	require.ErrorIs(t, q.push(waitCtx, PriorityLow, []byte("b"), true), context.Canceled)
+
+	q.close()
+	require.ErrorIs(t, q.push(ctx, PriorityNormal, []byte("d"), false), ErrConnectionClosed)
+}
+
+func TestRequestPriority(t *testing.T) {
+	serve := &Serve{priorityRules: []PriorityRule{
+		NewPathPrefixPriorityRule("/health", PriorityHigh),
+		NewPathPrefixPriorityRule("/batch", PriorityLow),
+	}}
+	tests := []struct {
+		path     string
+		header   string
+		priority Priority
+		err      bool
+	}{
+		{path: "/test", priority: PriorityNormal},
+		{path: "/health/live", priority: PriorityHigh},
+		{path: "/batch/1", priority: PriorityLow},
+		{path: "/batch/1", header: "High", priority: PriorityHigh},
+		{path: "/test", header: "urgent", err: true},
+	}
+	for _, tc := range tests {
+		r := httptest.NewRequest(http.MethodGet, tc.path, nil)
+		if tc.header != "" {
+			r.Header.Set(HeaderPriority, tc.header)
+		}
+		priority, err := serve.requestPriority(r)
+		if tc.err {
+			require.Error(t, err)
+			continue
+		}
+		require.NoError(t, err)
+		require.Equal(t, tc.priority, priority, tc.path)
+	}
+}
+
+func TestProxyPriority(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	codec := NewProtoCodec[*message.Message]()
+	serve := NewServe(ctx, &priorityProxyHandler{}, codec, WithServePriorityRules(NewPathPrefixPriorityRule("/batch", PriorityLow)))
+	server := httptest.NewServer(http.HandlerFunc(serve.HandleWS))
+	defer server.Close()
+
+	_, err := NewClient(ctx, "ws"+strings.TrimPrefix(server.URL, "http"), &priorityHandler{}, codec, WithClientID("4711")).GetConn()
+	require.NoError(t, err)
+
+	tests := []struct {
+		path   string
+		header string
+		status int
+		body   string
+	}{
+		{path: "/test", status: http.StatusOK, body: "normal"},
+		{path: "/batch", status: http.StatusOK, body: "low"},
+		{path: "/batch", header: "high", status: http.StatusOK, body: "high"},
+		{path: "/test", header: "urgent", status: http.StatusBadRequest},
+	}
+	for _, tc := range tests {
+		r := httptest.NewRequest(http.MethodGet, tc.path, nil)
+		r.Header.Set(HeaderClientId, "4711")
+		if tc.header != "" {
+			r.Header.Set(HeaderPriority, tc.header)
+		}
+		w := httptest.NewRecorder()
+		serve.HandleProxy(w, r)
+		require.Equal(t, tc.status, w.Code)
+		if tc.body != "" {
+			require.Equal(t, tc.body, w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,364 @@
+package ws
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"unicode/utf8"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// protoToMap converts the proto message into generic maps, slices and scalars, which can be marshaled by
+// schemaless formats like MessagePack or CBOR. Fields are keyed by the JSON name and enums are numbers.
+// Well known ListValue, Struct and Value types are converted to their native representation.
+// Like the proto encoding, it rejects strings which are not valid UTF-8.
+func protoToMap(m protoreflect.Message) (map[string]any, error) {
+	result := make(map[string]any)
+	var err error
+	m.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		result[fd.JSONName()], err = fieldToAny(fd, v)
+		return err == nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func fieldToAny(fd protoreflect.FieldDescriptor, v protoreflect.Value) (any, error) {
+	switch {
+	case fd.IsList():
+		list := v.List()
+		result := make([]any, list.Len())
+		for i := 0; i < list.Len(); i++ {
+			item, err := singularToAny(fd, list.Get(i))
+			if err != nil {
+				return nil, err
+			}
+			result[i] = item
+		}
+		return result, nil
+	case fd.IsMap():
+		result := make(map[string]any)
+		var err error
+		v.Map().Range(func(k protoreflect.MapKey, v protoreflect.Value) bool {
+			key := k.String()
+			if !utf8.ValidString(key) {
+				err = fmt.Errorf("field %s: invalid UTF-8 map key", fd.FullName())
+				return false
+			}
+			result[key], err = singularToAny(fd.MapValue(), v)
+			return err == nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		return result, nil
+	default:
+		return singularToAny(fd, v)
+	}
+}
+
+func singularToAny(fd protoreflect.FieldDescriptor, v protoreflect.Value) (any, error) {
+	switch fd.Kind() {
+	case protoreflect.EnumKind:
+		return int32(v.Enum()), nil
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		return messageToAny(v.Message())
+	case protoreflect.StringKind:
+		if !utf8.ValidString(v.String()) {
+			return nil, fmt.Errorf("field %s: invalid UTF-8 string", fd.FullName())
+		}
+		return v.String(), nil
+	default:
+		return v.Interface(), nil
+	}
+}
+
+func messageToAny(m protoreflect.Message) (any, error) {
+	switch msg := m.Interface().(type) {
+	case *structpb.ListValue:
+		return msg.AsSlice(), nil
+	case *structpb.Struct:
+		return msg.AsMap(), nil
+	case *structpb.Value:
+		return msg.AsInterface(), nil
+	default:
+		return protoToMap(m)
+	}
+}
+
+// mapToProto is the reverse of protoToMap. Unknown fields are ignored, enums are accepted as numbers or names.
+func mapToProto(data map[string]any, m protoreflect.Message) error {
+	fields := m.Descriptor().Fields()
+	for key, raw := range data {
+		fd := fields.ByJSONName(key)
+		if fd == nil {
+			fd = fields.ByName(protoreflect.Name(key))
+		}
+		if fd == nil || raw == nil {
+			continue
+		}
+		if err := setField(m, fd, raw); err != nil {
+			return fmt.Errorf("field %s: %w", fd.FullName(), err)
+		}
+	}
+	return nil
+}
+
+func setField(m protoreflect.Message, fd protoreflect.FieldDescriptor, raw any) error {
+	switch {
+	case fd.IsList():
+		items, ok := raw.([]any)
+		if !ok {
+			return fmt.Errorf("expected array, got %T", raw)
+		}
+		list := m.Mutable(fd).List()
+		for _, item := range items {
+			v, err := anyToSingular(fd, item, list.NewElement)
+			if err != nil {
+				return err
+			}
+			list.Append(v)
+		}
+	case fd.IsMap():
+		entries, ok := raw.(map[string]any)
+		if !ok {
+			return fmt.Errorf("expected map, got %T", raw)
+		}
+		mm := m.Mutable(fd).Map()
+		for k, item := range entries {
+			key, err := parseMapKey(fd.MapKey(), k)
+			if err != nil {
+				return err
+			}
+			v, err := anyToSingular(fd.MapValue(), item, mm.NewValue)
+			if err != nil {
+				return err
+			}
+			mm.Set(key, v)
+		}
+	default:
+		v, err := anyToSingular(fd, raw, func() protoreflect.Value { return m.NewField(fd) })
+		if err != nil {
+			return err
+		}
+		m.Set(fd, v)
+	}
+	return nil
+}
+
+func anyToSingular(fd protoreflect.FieldDescriptor, raw any, newMessage func() protoreflect.Value) (protoreflect.Value, error) {
+	switch fd.Kind() {
+	case protoreflect.EnumKind:
+		if name, ok := raw.(string); ok {
+			ev := fd.Enum().Values().ByName(protoreflect.Name(name))
+			if ev == nil {
+				return protoreflect.Value{}, fmt.Errorf("unknown enum value %s", name)
+			}
+			return protoreflect.ValueOfEnum(ev.Number()), nil
+		}
+		n, err := toInt64(raw, math.MinInt32, math.MaxInt32)
+		return protoreflect.ValueOfEnum(protoreflect.EnumNumber(n)), err
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		v := newMessage()
+		return v, anyToMessage(raw, v.Message())
+	case protoreflect.BoolKind:
+		b, ok := raw.(bool)
+		if !ok {
+			return protoreflect.Value{}, fmt.Errorf("expected bool, got %T", raw)
+		}
+		return protoreflect.ValueOfBool(b), nil
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		n, err := toInt64(raw, math.MinInt32, math.MaxInt32)
+		return protoreflect.ValueOfInt32(int32(n)), err
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		n, err := toInt64(raw, math.MinInt64, math.MaxInt64)
+		return protoreflect.ValueOfInt64(n), err
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		n, err := toUint64(raw, math.MaxUint32)
+		return protoreflect.ValueOfUint32(uint32(n)), err
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		n, err := toUint64(raw, math.MaxUint64)
+		return protoreflect.ValueOfUint64(n), err
+	case protoreflect.FloatKind:
+		f, err := toFloat64(raw)
+		return protoreflect.ValueOfFloat32(float32(f)), err
+	case protoreflect.DoubleKind:
+		f, err := toFloat64(raw)
+		return protoreflect.ValueOfFloat64(f), err
+	case protoreflect.StringKind:
+		s, ok := raw.(string)
+		if !ok {
+			return protoreflect.Value{}, fmt.Errorf("expected string, got %T", raw)
+		}
+		return protoreflect.ValueOfString(s), nil
+	case protoreflect.BytesKind:
+		switch b := raw.(type) {
+		case []byte:
+			return protoreflect.ValueOfBytes(b), nil
+		case string:
+			return protoreflect.ValueOfBytes([]byte(b)), nil
+		default:
+			return protoreflect.Value{}, fmt.Errorf("expected bytes, got %T", raw)
+		}
+	default:
+		return protoreflect.Value{}, fmt.Errorf("unsupported kind %s", fd.Kind())
+	}
+}
+
+func anyToMessage(raw any, m protoreflect.Message) error {
+	var wkt proto.Message
+	var err error
+	switch m.Interface().(type) {
+	case *structpb.ListValue:
+		items, ok := raw.([]any)
+		if !ok {
+			return fmt.Errorf("expected array, got %T", raw)
+		}
+		wkt, err = structpb.NewList(normalizeAny(items).([]any))
+	case *structpb.Struct:
+		entries, ok := raw.(map[string]any)
+		if !ok {
+			return fmt.Errorf("expected map, got %T", raw)
+		}
+		wkt, err = structpb.NewStruct(normalizeAny(entries).(map[string]any))
+	case *structpb.Value:
+		wkt, err = structpb.NewValue(normalizeAny(raw))
+	default:
+		entries, ok := raw.(map[string]any)
+		if !ok {
+			return fmt.Errorf("expected map, got %T", raw)
+		}
+		return mapToProto(entries, m)
+	}
+	if err != nil {
+		return err
+	}
+	proto.Merge(m.Interface(), wkt)
+	return nil
+}
+
+// normalizeAny converts decoded values to the types accepted by structpb.
+func normalizeAny(raw any) any {
+	switch v := raw.(type) {
+	case []any:
+		result := make([]any, len(v))
+		for i, item := range v {
+			result[i] = normalizeAny(item)
+		}
+		return result
+	case map[string]any:
+		result := make(map[string]any, len(v))
+		for k, item := range v {
+			result[k] = normalizeAny(item)
+		}
+		return result
+	case map[any]any:
+		result := make(map[string]any, len(v))
+		for k, item := range v {
+			result[fmt.Sprint(k)] = normalizeAny(item)
+		}
+		return result
+	default:
+		return v
+	}
+}
+
+func parseMapKey(fd protoreflect.FieldDescriptor, key string) (protoreflect.MapKey, error) {
+	switch fd.Kind() {
+	case protoreflect.StringKind:
+		return protoreflect.ValueOfString(key).MapKey(), nil
+	case protoreflect.BoolKind:
+		b, err := strconv.ParseBool(key)
+		return protoreflect.ValueOfBool(b).MapKey(), err
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		n, err := strconv.ParseInt(key, 10, 32)
+		return protoreflect.ValueOfInt32(int32(n)).MapKey(), err
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		n, err := strconv.ParseInt(key, 10, 64)
+		return protoreflect.ValueOfInt64(n).MapKey(), err
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		n, err := strconv.ParseUint(key, 10, 32)
+		return protoreflect.ValueOfUint32(uint32(n)).MapKey(), err
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		n, err := strconv.ParseUint(key, 10, 64)
+		return protoreflect.ValueOfUint64(n).MapKey(), err
+	default:
+		return protoreflect.MapKey{}, fmt.Errorf("unsupported map key kind %s", fd.Kind())
+	}
+}
+
+func toInt64(raw any, minValue int64, maxValue int64) (int64, error) {
+	var n int64
+	switch v := raw.(type) {
+	case int:
+		n = int64(v)
+	case int8:
+		n = int64(v)
+	case int16:
+		n = int64(v)
+	case int32:
+		n = int64(v)
+	case int64:
+		n = v
+	case uint8:
+		n = int64(v)
+	case uint16:
+		n = int64(v)
+	case uint32:
+		n = int64(v)
+	case uint64:
+		if v > math.MaxInt64 {
+			return 0, fmt.Errorf("value %d out of range", v)
+		}
+		n = int64(v)
+	default:
+		return 0, fmt.Errorf("expected integer, got %T", raw)
+	}
+	if n < minValue || n > maxValue {
+		return 0, fmt.Errorf("value %d out of range", n)
+	}
+	return n, nil
+}
+
+func toUint64(raw any, maxValue uint64) (uint64, error) {
+	var n uint64
+	switch v := raw.(type) {
+	case uint:
+		n = uint64(v)
+	case uint8:
+		n = uint64(v)
+	case uint16:
+		n = uint64(v)
+	case uint32:
+		n = uint64(v)
+	case uint64:
+		n = v
+	default:
+		i, err := toInt64(raw, 0, math.MaxInt64)
+		if err != nil {
+			return 0, err
+		}
+		n = uint64(i)
+	}
+	if n > maxValue {
+		return 0, fmt.Errorf("value %d out of range", n)
+	}
+	return n, nil
+}
+
+func toFloat64(raw any) (float64, error) {
+	switch v := raw.(type) {
+	case float32:
+		return float64(v), nil
+	case float64:
+		return v, nil
+	default:
+		i, err := toInt64(raw, math.MinInt64, math.MaxInt64)
+		return float64(i), err
+	}
+}
@@ -0,0 +1,208 @@
+package ws
+
+import (
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	rateLimitPruneInterval = time.Minute
+	// throttled counters of the client IDs are removed after the idle time.
+	rateLimitThrottledIdle = 10 * time.Minute
+)
+
+// RateLimit is a token bucket refilled with Rate tokens per second up to Burst tokens.
+// A zero or negative rate means no limit, a burst less than one is taken as one.
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+func rateLimitOrDefault(limit RateLimit) RateLimit {
+	if limit.Rate > 0 && limit.Burst < 1 {
+		slog.Warn("rate limit burst must be at least 1, using 1", slog.Int("burst", limit.Burst))
+		limit.Burst = 1
+	}
+	return limit
+}
+
+// RateLimitKeyFunc returns the key of the token bucket the request is charged to.
+type RateLimitKeyFunc func(r *http.Request, clientID string) string
+
+// RateLimitByClientID charges the requests to the target client ID.
+func RateLimitByClientID() RateLimitKeyFunc {
+	return func(_ *http.Request, clientID string) string {
+		return clientID
+	}
+}
+
+// RateLimitByCaller charges the requests to the caller identified by the header value or by the remote address.
+func RateLimitByCaller(header string) RateLimitKeyFunc {
+	return func(r *http.Request, _ string) string {
+		return callerIdentity(r, header)
+	}
+}
+
+// RateLimitByClientIDAndCaller charges the requests to the pair of the target client ID and the caller.
+func RateLimitByClientIDAndCaller(header string) RateLimitKeyFunc {
+	return func(r *http.Request, clientID string) string {
+		return clientID + "\x00" + callerIdentity(r, header)
+	}
+}
+
+func callerIdentity(r *http.Request, header string) string {
+	if header != "" {
+		if value := r.Header.Get(header); value != "" {
+			return value
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// RateLimitMetrics is a snapshot of the throttled request counters.
+// Client IDs which were not throttled for 10 minutes are removed from ThrottledByClientID.
+type RateLimitMetrics struct {
+	Throttled           uint64
+	ThrottledByClientID map[string]uint64
+}
+
+type throttledCounter struct {
+	count uint64
+	last  time.Time
+}
+
+type tokenBucket struct {
+	limit  RateLimit
+	tokens float64
+	last   time.Time
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	b.tokens = math.Min(float64(b.limit.Burst), b.tokens+now.Sub(b.last).Seconds()*b.limit.Rate)
+	b.last = now
+}
+
+// RateLimiter limits the proxy requests with token buckets.
+type RateLimiter struct {
+	keyFunc      RateLimitKeyFunc
+	limit        RateLimit
+	clientLimits map[string]RateLimit
+
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	pruned    time.Time
+	throttled map[string]*throttledCounter
+	total     uint64
+}
+
+// NewRateLimiter creates the rate limiter with the default limit and the limits of the client IDs.
+func NewRateLimiter(keyFunc RateLimitKeyFunc, limit RateLimit, clientLimits map[string]RateLimit) *RateLimiter {
+	limits := make(map[string]RateLimit, len(clientLimits))
+	for clientID, clientLimit := range clientLimits {
+		limits[clientID] = rateLimitOrDefault(clientLimit)
+	}
+	return &RateLimiter{
+		keyFunc:      keyFunc,
+		limit:        rateLimitOrDefault(limit),
+		clientLimits: limits,
+		buckets:      make(map[string]*tokenBucket),
+		throttled:    make(map[string]*throttledCounter),
+	}
+}
+
+// Allow takes a token for the request, if there is none it returns the time after which the request can be retried.
+func (l *RateLimiter) Allow(r *http.Request, clientID string) (bool, time.Duration) {
+	limit := l.limit
+	if clientLimit, ok := l.clientLimits[clientID]; ok {
+		limit = clientLimit
+	}
+	if limit.Rate <= 0 {
+		return true, 0
+	}
+	key := l.keyFunc(r, clientID)
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.prune(now)
+	bucket, ok := l.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: float64(limit.Burst), last: now}
+		l.buckets[key] = bucket
+	}
+	// a bucket keyed by the caller can be charged with the limits of different client IDs
+	bucket.limit = limit
+	bucket.refill(now)
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		return true, 0
+	}
+	l.total++
+	counter, ok := l.throttled[clientID]
+	if !ok {
+		counter = &throttledCounter{}
+		l.throttled[clientID] = counter
+	}
+	counter.count++
+	counter.last = now
+	return false, time.Duration((1 - bucket.tokens) / limit.Rate * float64(time.Second))
+}
+
+// prune removes the full buckets, which are equivalent to missing ones, and the idle throttled counters.
+func (l *RateLimiter) prune(now time.Time) {
+	if now.Sub(l.pruned) < rateLimitPruneInterval {
+		return
+	}
+	for key, bucket := range l.buckets {
+		bucket.refill(now)
+		if bucket.tokens >= float64(bucket.limit.Burst) {
+			delete(l.buckets, key)
+		}
+	}
+	for clientID, counter := range l.throttled {
+		if now.Sub(counter.last) > rateLimitThrottledIdle {
+			delete(l.throttled, clientID)
+		}
+	}
+	l.pruned = now
+}
+
+func (l *RateLimiter) Metrics() RateLimitMetrics {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	metrics := RateLimitMetrics{
+		Throttled:           l.total,
+		ThrottledByClientID: make(map[string]uint64, len(l.throttled)),
+	}
+	for clientID, counter := range l.throttled {
+		metrics.ThrottledByClientID[clientID] = counter.count
+	}
+	return metrics
+}
+
+// allowRequest responds with 429 if the request exceeds the rate limit.
+// Requests forwarded by authenticated replicas were already limited by them.
+func (s *Serve) allowRequest(w http.ResponseWriter, r *http.Request, clientID string) bool {
+	if s.rateLimiter == nil || forwardedBy(r) != "" {
+		return true
+	}
+	ok, retryAfter := s.rateLimiter.Allow(r, clientID)
+	if ok {
+		return true
+	}
+	s.logger.Warn("proxy request throttled", slog.String("client-id", clientID))
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
+	return false
+}
@@ -0,0 +1,151 @@
+package ws
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/grepplabs/backstream/internal/message"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateLimiter(t *testing.T) {
+	limiter := NewRateLimiter(RateLimitByClientIDAndCaller("x-caller"), RateLimit{Rate: 1, Burst: 2}, map[string]RateLimit{
+		"unlimited": {},
+		"slow":      {Rate: 0.1, Burst: 1},
+	})
+	request := func(caller string) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "/test", nil)
+		r.Header.Set("x-caller", caller)
+		return r
+	}
+
+	for i := 0; i < 2; i++ {
+		ok, _ := limiter.Allow(request("a"), "4711")
+		require.True(t, ok)
+	}
+	ok, retryAfter := limiter.Allow(request("a"), "4711")
+	require.False(t, ok)
+	require.Greater(t, retryAfter, time.Duration(0))
+	require.LessOrEqual(t, retryAfter, time.Second)
+
+	// other caller and other client ID have own buckets
+	ok, _ = limiter.Allow(request("b"), "4711")
+	require.True(t, ok)
+	ok, _ = limiter.Allow(request("a"), "4712")
+	require.True(t, ok)
+
+	for i := 0; i < 10; i++ {
+		ok, _ = limiter.Allow(request("a"), "unlimited")
+		require.True(t, ok)
+	}
+
+	ok, _ = limiter.Allow(request("a"), "slow")
+	require.True(t, ok)
+	ok, retryAfter = limiter.Allow(request("a"), "slow")
+	require.False(t, ok)
+	require.Greater(t, retryAfter, 9*time.Second)
+
+	require.Equal(t, RateLimitMetrics{
+		Throttled:           2,
+		ThrottledByClientID: map[string]uint64{"4711": 1, "slow": 1},
+	}, limiter.Metrics())
+
+	// idle throttled counters are removed
+	limiter.prune(time.Now().Add(rateLimitThrottledIdle + time.Second))
+	require.Equal(t, RateLimitMetrics{Throttled: 2, ThrottledByClientID: map[string]uint64{}}, limiter.Metrics())
+	require.Empty(t, limiter.buckets)
+
+	r := httptest.NewRequest(http.MethodGet, "/test", nil)
+	r.RemoteAddr = "10.0.0.1:1234"
+	require.Equal(t, "10.0.0.1", RateLimitByCaller("x-caller")(r, "4711"))
+	require.Equal(t, "4711", RateLimitByClientID()(r, "4711"))
+}
+
+func TestRateLimiterBurst(t *testing.T) {
+	limiter := NewRateLimiter(RateLimitByClientID(), RateLimit{Rate: 0.1}, map[string]RateLimit{
+		"4711": {Rate: 0.1, Burst: -1},
+	})
+	for _, clientID := range []string{"4711", "4712"} {
+		// a burst less than one is taken as one
+		ok, _ := limiter.Allow(httptest.NewRequest(http.MethodGet, "/test", nil), clientID)
+		require.True(t, ok)
+		ok, _ = limiter.Allow(httptest.NewRequest(http.MethodGet, "/test", nil), clientID)
+		require.False(t, ok)
+	}
+}
+
+func TestServeRateLimit(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	limiter := NewRateLimiter(RateLimitByClientID(), RateLimit{Rate: 0.5, Burst: 1}, nil)
+	serve := NewServe(ctx, &noopProxyHandler{}, NewProtoCodec[*message.Message](), WithServeRateLimiter(limiter))
+
+	r := httptest.NewRequest(http.MethodGet, "/test", nil)
+	r.Header.Set(HeaderClientId, "4711")
+	w := httptest.NewRecorder()
+	serve.HandleProxy(w, r)
+	require.Equal(t, http.StatusUnprocessableEntity, w.Code)
+
+	w = httptest.NewRecorder()
+	serve.HandleProxy(w, r)
+	require.Equal(t, http.StatusTooManyRequests, w.Code)
+	require.Equal(t, "2", w.Header().Get("Retry-After"))
+
+	w = httptest.NewRecorder()
+	serve.HandleProxyWithRetry(w, r)
+	require.Equal(t, http.StatusTooManyRequests, w.Code)
+
+	// the forwarded-by header is not trusted without the signature
+	r.Header.Set(HeaderForwardedBy, "http://10.0.0.2:8080")
+	w = httptest.NewRecorder()
+	serve.HandleProxy(w, r)
+	require.Equal(t, http.StatusTooManyRequests, w.Code)
+
+	require.Equal(t, uint64(3), limiter.Metrics().Throttled)
+}
+
+func TestServeRateLimitForwarded(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	limiter := NewRateLimiter(RateLimitByClientID(), RateLimit{Rate: 0.5, Burst: 1}, nil)
+	serve := NewServe(ctx, &noopProxyHandler{}, NewProtoCodec[*message.Message](), WithServeRateLimiter(limiter),
+		WithServeClusterSecret([]byte("secret")))
+	forwarder := NewServe(ctx, &noopProxyHandler{}, NewProtoCodec[*message.Message](),
+		WithServeCluster(nil, "http://10.0.0.2:8080"), WithServeClusterSecret([]byte("secret")))
+	other := NewServe(ctx, &noopProxyHandler{}, NewProtoCodec[*message.Message](),
+		WithServeCluster(nil, "http://10.0.0.3:8080"), WithServeClusterSecret([]byte("other")))
+
+	newRequest := func(s *Serve) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "/test", nil)
+		r.Header.Set(HeaderClientId, "4711")
+		s.signForward(r, "4711")
+		return r
+	}
+	// forwarded requests were limited by the forwarding replica
+	for i := 0; i < 3; i++ {
+		w := httptest.NewRecorder()
+		serve.HandleProxy(w, newRequest(forwarder))
+		require.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	}
+	require.Equal(t, uint64(0), limiter.Metrics().Throttled)
+
+	// signed with another secret
+	w := httptest.NewRecorder()
+	serve.HandleProxy(w, newRequest(other))
+	require.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	w = httptest.NewRecorder()
+	serve.HandleProxy(w, newRequest(other))
+	require.Equal(t, http.StatusTooManyRequests, w.Code)
+
+	// the signature does not cover another client ID
+	r := newRequest(forwarder)
+	r = serve.authenticateForward(r, "4712")
+	require.Empty(t, forwardedBy(r))
+	require.True(t, isForwarded(r))
+	require.Empty(t, r.Header.Get(HeaderForwardedBy))
+}
@@ -0,0 +1,117 @@
+package ws
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// ClientIDResolver derives the target client ID from the proxy request.
+// It returns the request which should be proxied, so that the resolver can strip routing information.
+// An empty client ID is returned if the request does not carry it.
+type ClientIDResolver interface {
+	ResolveClientID(r *http.Request) (string, *http.Request, error)
+}
+
+type ClientIDResolverFunc func(r *http.Request) (string, *http.Request, error)
+
+func (f ClientIDResolverFunc) ResolveClientID(r *http.Request) (string, *http.Request, error) {
+	return f(r)
+}
+
+// NewHeaderResolver takes the client ID from the request header.
+func NewHeaderResolver(header string) ClientIDResolver {
+	return ClientIDResolverFunc(func(r *http.Request) (string, *http.Request, error) {
+		return r.Header.Get(header), r, nil
+	})
+}
+
+// NewHostResolver takes the client ID from the host, e.g. the suffix ".tunnel.example.com"
+// resolves the host "4711.tunnel.example.com" to the client ID "4711".
+func NewHostResolver(suffix string) ClientIDResolver {
+	suffix = strings.ToLower(suffix)
+	return ClientIDResolverFunc(func(r *http.Request) (string, *http.Request, error) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		host = strings.ToLower(host)
+		if !strings.HasSuffix(host, suffix) {
+			return "", r, nil
+		}
+		clientID := strings.TrimSuffix(host, suffix)
+		if clientID == "" || strings.Contains(clientID, ".") {
+			return "", r, nil
+		}
+		return clientID, r, nil
+	})
+}
+
+// NewPathPrefixResolver takes the client ID from the first path segment after the prefix and strips both,
+// e.g. the prefix "/c/" resolves the path "/c/4711/test" to the client ID "4711" and the path "/test".
+func NewPathPrefixResolver(prefix string) ClientIDResolver {
+	return ClientIDResolverFunc(func(r *http.Request) (string, *http.Request, error) {
+		rest, ok := strings.CutPrefix(r.URL.Path, prefix)
+		if !ok {
+			return "", r, nil
+		}
+		clientID, path, _ := strings.Cut(rest, "/")
+		if clientID == "" {
+			return "", r, nil
+		}
+		rawPath := ""
+		if r.URL.RawPath != "" {
+			rawRest, ok := strings.CutPrefix(r.URL.RawPath, prefix)
+			if !ok {
+				return "", r, nil
+			}
+			_, rawPath, _ = strings.Cut(rawRest, "/")
+			rawPath = "/" + rawPath
+		}
+		r2 := new(http.Request)
+		*r2 = *r
+		r2.URL = new(url.URL)
+		*r2.URL = *r.URL
+		r2.URL.Path = "/" + path
+		r2.URL.RawPath = rawPath
+		return clientID, r2, nil
+	})
+}
+
+// NewQueryResolver takes the client ID from the query parameter and removes the parameter from the query.
+func NewQueryResolver(param string) ClientIDResolver {
+	return ClientIDResolverFunc(func(r *http.Request) (string, *http.Request, error) {
+		query, err := url.ParseQuery(r.URL.RawQuery)
+		if err != nil {
+			return "", nil, err
+		}
+		clientID := query.Get(param)
+		if clientID == "" {
+			return "", r, nil
+		}
+		query.Del(param)
+		r2 := new(http.Request)
+		*r2 = *r
+		r2.URL = new(url.URL)
+		*r2.URL = *r.URL
+		r2.URL.RawQuery = query.Encode()
+		return clientID, r2, nil
+	})
+}
+
+// NewChainResolver returns the first non-empty client ID resolved by the resolvers.
+func NewChainResolver(resolvers ...ClientIDResolver) ClientIDResolver {
+	return ClientIDResolverFunc(func(r *http.Request) (string, *http.Request, error) {
+		for _, resolver := range resolvers {
+			clientID, r2, err := resolver.ResolveClientID(r)
+			if err != nil {
+				return "", nil, err
+			}
+			if clientID != "" {
+				return clientID, r2, nil
+			}
+		}
+		return "", r, nil
+	})
+}
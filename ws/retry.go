@@ -0,0 +1,217 @@
+package ws
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+const HeaderIdempotencyKey = "Idempotency-Key"
+
+// RetryPolicy configures the retries of HandleProxyWithRetry.
+// Only requests with idempotent methods or with the Idempotency-Key header are retried,
+// the request body is buffered for the replay up to MaxBodySize bytes.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of attempts including the first one.
+	MaxAttempts int
+	// InitialBackoff is the wait before the first retry, it doubles with every retry up to MaxBackoff.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// MaxBodySize is the maximum size of the buffered request body, larger requests are not retried.
+	// Zero means the MaxMessageSize of the connection config.
+	MaxBodySize int64
+}
+
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: 100 * time.Millisecond,
+		MaxBackoff:     2 * time.Second,
+	}
+}
+
+func (p RetryPolicy) backoff(retry int) time.Duration {
+	backoff := p.InitialBackoff
+	for i := 1; i < retry && backoff < p.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	return min(backoff, p.MaxBackoff)
+}
+
+func isIdempotent(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return r.Header.Get(HeaderIdempotencyKey) != ""
+}
+
+// isRetryable reports whether the request can be retried on another connection. The request was not sent
+// if the send queue is full or not processed if the agent is overloaded. The connection can be closed after
+// the agent processed the request, this is safe as only idempotent requests are retried.
+func isRetryable(err error) bool {
+	return errors.Is(err, ErrConnectionClosed) || errors.Is(err, ErrOverloaded) || errors.Is(err, ErrQueueFull)
+}
+
+// bufferBody reads the request body to be replayed, it returns false if the body exceeds the limit.
+func bufferBody(r *http.Request, limit int64) (bool, error) {
+	if r.Body == nil || r.Body == http.NoBody {
+		r.GetBody = func() (io.ReadCloser, error) {
+			return http.NoBody, nil
+		}
+		r.Body = http.NoBody
+		return true, nil
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, limit+1))
+	if err != nil {
+		return false, err
+	}
+	if int64(len(body)) > limit {
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+		return false, nil
+	}
+	_ = r.Body.Close()
+	r.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(body)), nil
+	}
+	r.Body, _ = r.GetBody()
+	return true, nil
+}
+
+// bufferedResponse collects the response of an attempt, it is written only if the attempt succeeds.
+type bufferedResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func newBufferedResponse() *bufferedResponse {
+	return &bufferedResponse{
+		header: make(http.Header),
+		status: http.StatusOK,
+	}
+}
+
+func (b *bufferedResponse) Header() http.Header {
+	return b.header
+}
+
+func (b *bufferedResponse) Write(data []byte) (int, error) {
+	return b.body.Write(data)
+}
+
+func (b *bufferedResponse) WriteHeader(status int) {
+	b.status = status
+}
+
+func (b *bufferedResponse) writeTo(w http.ResponseWriter) {
+	for k, vs := range b.header {
+		w.Header()[k] = vs
+	}
+	w.WriteHeader(b.status)
+	_, _ = w.Write(b.body.Bytes())
+}
+
+// pickRetryConn picks a connection which was not tried yet, reconnected agents have new connections.
+func (s *Serve) pickRetryConn(r *http.Request, clientID string, tried map[*Conn]bool) (*Conn, error) {
+	conns, err := s.matchConns(r, clientID)
+	if err != nil {
+		return nil, err
+	}
+	untried := conns[:0]
+	for _, conn := range conns {
+		if !tried[conn] {
+			untried = append(untried, conn)
+		}
+	}
+	return s.balancer.Pick(untried), nil
+}
+
+func (s *Serve) HandleProxyWithRetry(w http.ResponseWriter, r *http.Request) {
+	clientID, r, err := s.resolveClientID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !s.allowRequest(w, r, clientID) {
+		return
+	}
+	if r, err = s.withRequestPriority(r); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	conn, err := s.pickRetryConn(r, clientID, nil)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if conn == nil {
+		if conn = s.handleConnNotFound(w, r, clientID); conn == nil {
+			return
+		}
+	}
+	policy := s.retryPolicy
+	maxAttempts := 1
+	if isIdempotent(r) {
+		maxBodySize := policy.MaxBodySize
+		if maxBodySize <= 0 {
+			maxBodySize = s.connConfig.MaxMessageSize
+		}
+		replayable, err := bufferBody(r, maxBodySize)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if replayable {
+			maxAttempts = max(1, policy.MaxAttempts)
+		}
+	}
+	tried := make(map[*Conn]bool)
+	for attempt := 1; ; attempt++ {
+		if attempt > 1 {
+			r.Body, _ = r.GetBody()
+		}
+		tried[conn] = true
+		resp := newBufferedResponse()
+		err = s.handler.ProxyRequest(conn, resp, r)
+		if err == nil {
+			resp.writeTo(w)
+			return
+		}
+		if attempt >= maxAttempts || !isRetryable(err) {
+			break
+		}
+		s.logger.Warn("proxy request attempt failed", slog.String("client-id", clientID), slog.Int("attempt", attempt), slog.String("error", err.Error()))
+		if conn = s.waitRetryConn(r.Context(), r, clientID, tried, policy.backoff(attempt)); conn == nil {
+			break
+		}
+	}
+	s.writeProxyError(w, err)
+}
+
+// waitRetryConn returns an untried connection, if there is none it waits for the backoff and tries again.
+func (s *Serve) waitRetryConn(ctx context.Context, r *http.Request, clientID string, tried map[*Conn]bool, backoff time.Duration) *Conn {
+	if conn, _ := s.pickRetryConn(r, clientID, tried); conn != nil {
+		return conn
+	}
+	timer := time.NewTimer(backoff)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return nil
+	case <-timer.C:
+	}
+	conn, _ := s.pickRetryConn(r, clientID, tried)
+	if conn == nil {
+		// the only agent is overloaded, retry it after the backoff
+		conn, _ = s.pickRetryConn(r, clientID, nil)
+	}
+	return conn
+}
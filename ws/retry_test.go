@@ -0,0 +1,65 @@
+package ws
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetryPolicy(t *testing.T) {
+	policy := RetryPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second}
+	require.Equal(t, 100*time.Millisecond, policy.backoff(1))
+	require.Equal(t, 200*time.Millisecond, policy.backoff(2))
+	require.Equal(t, 800*time.Millisecond, policy.backoff(4))
+	require.Equal(t, time.Second, policy.backoff(5))
+	require.Equal(t, time.Second, policy.backoff(50))
+
+	require.True(t, isIdempotent(httptest.NewRequest(http.MethodGet, "/", nil)))
+	require.True(t, isIdempotent(httptest.NewRequest(http.MethodDelete, "/", nil)))
+	post := httptest.NewRequest(http.MethodPost, "/", nil)
+	require.False(t, isIdempotent(post))
+	post.Header.Set(HeaderIdempotencyKey, "4711")
+	require.True(t, isIdempotent(post))
+
+	require.True(t, isRetryable(ErrConnectionClosed))
+	require.True(t, isRetryable(ErrOverloaded))
+	require.True(t, isRetryable(fmt.Errorf("%w: %s priority", ErrQueueFull, PriorityNormal)))
+	require.False(t, isRetryable(context.DeadlineExceeded))
+}
+
+func TestBufferBody(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPut, "/", strings.NewReader("payload"))
+	ok, err := bufferBody(r, 7)
+	require.NoError(t, err)
+	require.True(t, ok)
+	for i := 0; i < 2; i++ {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		require.Equal(t, "payload", string(body))
+		r.Body, _ = r.GetBody()
+	}
+
+	r = httptest.NewRequest(http.MethodPut, "/", strings.NewReader("payload"))
+	ok, err = bufferBody(r, 6)
+	require.NoError(t, err)
+	require.False(t, ok)
+	body, err := io.ReadAll(r.Body)
+	require.NoError(t, err)
+	require.Equal(t, "payload", string(body))
+
+	// requests without a body are replayed too
+	r = httptest.NewRequest(http.MethodGet, "/", nil)
+	ok, err = bufferBody(r, 6)
+	require.NoError(t, err)
+	require.True(t, ok)
+	r.Body, err = r.GetBody()
+	require.NoError(t, err)
+	require.Equal(t, http.NoBody, r.Body)
+}
@@ -6,20 +6,25 @@ import (
 	"fmt"
 	"log/slog"
 	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"slices"
+	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/grepplabs/backstream/internal/message"
+	"github.com/grepplabs/backstream/registry"
 )
 
-const HeaderClientId = "x-backstream-client-id"
+const (
+	HeaderClientId    = "x-backstream-client-id"
+	HeaderForwardedBy = "x-backstream-forwarded-by"
+	// HeaderRequestTimeout is the timeout of the proxy request, it also bounds the agent wait.
+	HeaderRequestTimeout = "x-backstream-request-timeout"
 
-var upgrader = websocket.Upgrader{
-	ReadBufferSize:  1024,
-	WriteBufferSize: 1024,
-	CheckOrigin: func(r *http.Request) bool {
-		return true
-	},
-}
+	registryTimeout    = 5 * time.Second
+	defaultRegistryTTL = 30 * time.Second
+)
 
 type Serve struct {
 	pool            *Pool
@@ -28,6 +33,40 @@ type Serve struct {
 	logger          *slog.Logger
 	requireClientId bool
 	codec           Codec[*message.Message]
+	// codecs selected by the WebSocket subprotocol
+	subprotocols      []string
+	subprotocolCodecs map[string]Codec[*message.Message]
+	balancer          Balancer
+	resolver          ClientIDResolver
+	features          []string
+	capabilities      *Capabilities
+	// permessage-deflate
+	compression      bool
+	compressionLevel int
+	signing          *messageSigning
+	connConfig       ConnConfig
+	rateLimiter      *RateLimiter
+	retryPolicy      RetryPolicy
+	agentWait        time.Duration
+	priorityRules    []PriorityRule
+	// WebSocket upgrade
+	upgrader          websocket.Upgrader
+	extraSubprotocols []string
+	responseHeader    http.Header
+	// client ID conflicts
+	conflictPolicy  ConflictPolicy
+	conflictHandler func(ConflictEvent)
+	conflicts       conflictCounters
+	// cluster mode
+	registry         registry.Registry
+	registryTTL      time.Duration
+	advertiseURL     string
+	clusterSigner    Signer
+	forwardTransport http.RoundTripper
+	// store-and-forward notifications
+	outbox            *outboxDelivery
+	outboxMaxAttempts int
+	outboxDeadLetter  func(OutboxDeadLetter)
 }
 
 type ServeOption func(*Serve)
@@ -38,12 +77,198 @@ func WithServeLogger(logger *slog.Logger) ServeOption {
 	}
 }
 
+// WithServeSubprotocolCodec adds a codec selected when the agent requests the WebSocket subprotocol.
+// The subprotocols are preferred in the order they were added. The codec passed to NewServe is
+// registered under its own subprotocol and used for agents which do not request any subprotocol.
+func WithServeSubprotocolCodec(subprotocol string, codec Codec[*message.Message]) ServeOption {
+	return func(s *Serve) {
+		s.addSubprotocolCodec(subprotocol, codec)
+	}
+}
+
+// WithServeBalancer sets the balancer choosing the connection among the connections matching the proxy request.
+func WithServeBalancer(balancer Balancer) ServeOption {
+	return func(s *Serve) {
+		s.balancer = balancer
+	}
+}
+
+// WithServeClientIDResolver sets how the target client ID is derived from the proxy request, by default it is
+// taken from the x-backstream-client-id header.
+func WithServeClientIDResolver(resolver ClientIDResolver) ServeOption {
+	return func(s *Serve) {
+		s.resolver = resolver
+	}
+}
+
+// WithServeFeatures sets the protocol features offered to the agents in the handshake.
+// FeatureCompression is offered depending on the codec, see NewCompressingCodec.
+func WithServeFeatures(features ...string) ServeOption {
+	return func(s *Serve) {
+		s.features = features
+	}
+}
+
+// WithServeCompression enables the permessage-deflate WebSocket extension with the flate compression level.
+// It is used only for agents which request it.
+func WithServeCompression(level int) ServeOption {
+	return func(s *Serve) {
+		s.compression = true
+		s.compressionLevel = level
+	}
+}
+
+// WithServeMessageSigning signs the messages and rejects the agent messages which are not signed, are tampered
+// or replayed. The message timestamps must be within the replay window, the nonces must not repeat within it.
+// The signature covers the sender role, so messages cannot be reflected back to their sender.
+// Connections are closed after 10 rejected messages.
+func WithServeMessageSigning(signer Signer, replayWindow time.Duration) ServeOption {
+	return func(s *Serve) {
+		s.signing = newMessageSigning(signer, replayWindow, roleProxy)
+	}
+}
+
+// WithServeConnConfig sets the connection timing and size parameters.
+// An invalid config is logged and the default config is used.
+func WithServeConnConfig(config ConnConfig) ServeOption {
+	return func(s *Serve) {
+		s.connConfig = config
+	}
+}
+
+// WithServeRateLimiter limits the proxy requests, requests exceeding the limit are rejected with 429.
+func WithServeRateLimiter(limiter *RateLimiter) ServeOption {
+	return func(s *Serve) {
+		s.rateLimiter = limiter
+	}
+}
+
+// WithServeRetryPolicy sets the retry policy of HandleProxyWithRetry.
+func WithServeRetryPolicy(policy RetryPolicy) ServeOption {
+	return func(s *Serve) {
+		s.retryPolicy = policy
+	}
+}
+
+// WithServeAgentWait holds proxy requests for client IDs without a connection until the agent connects,
+// at most for the wait duration, the x-backstream-request-timeout of the request or until the request is canceled.
+func WithServeAgentWait(wait time.Duration) ServeOption {
+	return func(s *Serve) {
+		s.agentWait = wait
+	}
+}
+
+// WithServePriorityRules sets the rules deciding the send priority of proxy requests without the
+// x-backstream-priority header, the first matching rule applies.
+func WithServePriorityRules(rules ...PriorityRule) ServeOption {
+	return func(s *Serve) {
+		s.priorityRules = rules
+	}
+}
+
+// WithServeAllowedOrigins accepts the upgrade requests only without the Origin header or with one of the origins,
+// e.g. https://app.example.com or https://*.example.com. By default, all origins are accepted.
+func WithServeAllowedOrigins(origins ...string) ServeOption {
+	return func(s *Serve) {
+		s.upgrader.CheckOrigin = allowedOrigins(origins)
+	}
+}
+
+// WithServeCheckOrigin sets the function accepting the Origin header of the upgrade requests.
+func WithServeCheckOrigin(checkOrigin func(r *http.Request) bool) ServeOption {
+	return func(s *Serve) {
+		s.upgrader.CheckOrigin = checkOrigin
+	}
+}
+
+// WithServeBufferSizes sets the I/O buffer sizes of the connections in bytes.
+func WithServeBufferSizes(readBufferSize, writeBufferSize int) ServeOption {
+	return func(s *Serve) {
+		s.upgrader.ReadBufferSize = readBufferSize
+		s.upgrader.WriteBufferSize = writeBufferSize
+	}
+}
+
+// WithServeWriteBufferPool shares the write buffers of the connections, it saves memory with many idle agents.
+func WithServeWriteBufferPool(pool websocket.BufferPool) ServeOption {
+	return func(s *Serve) {
+		s.upgrader.WriteBufferPool = pool
+	}
+}
+
+// WithServeUpgradeTimeout sets the time allowed to complete the WebSocket upgrade.
+func WithServeUpgradeTimeout(timeout time.Duration) ServeOption {
+	return func(s *Serve) {
+		s.upgrader.HandshakeTimeout = timeout
+	}
+}
+
+// WithServeSubprotocols accepts further WebSocket subprotocols, e.g. the ones required by API gateways.
+// They are preferred after the codec subprotocols and use the codec passed to NewServe.
+func WithServeSubprotocols(subprotocols ...string) ServeOption {
+	return func(s *Serve) {
+		s.extraSubprotocols = append(s.extraSubprotocols, subprotocols...)
+	}
+}
+
+// WithServeResponseHeader adds the headers to the upgrade responses, e.g. Set-Cookie.
+func WithServeResponseHeader(header http.Header) ServeOption {
+	return func(s *Serve) {
+		s.responseHeader = header
+	}
+}
+
+// WithServeConflictPolicy sets how agents connecting with the client ID of another agent instance are handled.
+func WithServeConflictPolicy(policy ConflictPolicy) ServeOption {
+	return func(s *Serve) {
+		s.conflictPolicy = policy
+	}
+}
+
+// WithServeConflictHandler sets the function called on client ID conflicts, it must not block.
+func WithServeConflictHandler(handler func(ConflictEvent)) ServeOption {
+	return func(s *Serve) {
+		s.conflictHandler = handler
+	}
+}
+
 func WithRequireClientId(b bool) ServeOption {
 	return func(s *Serve) {
 		s.requireClientId = b
 	}
 }
 
+// WithServeCluster enables the cluster mode. Client ID ownership is published to the registry
+// under the advertiseURL, proxy requests for client IDs owned by other replicas are forwarded to them.
+func WithServeCluster(registry registry.Registry, advertiseURL string) ServeOption {
+	return func(s *Serve) {
+		s.registry = registry
+		s.advertiseURL = advertiseURL
+	}
+}
+
+// WithServeRegistryTTL sets the lease TTL of the ownership records. Leases are refreshed every third of the TTL.
+// The default TTL is used if the TTL is not positive.
+func WithServeRegistryTTL(ttl time.Duration) ServeOption {
+	return func(s *Serve) {
+		s.registryTTL = ttl
+	}
+}
+
+// WithServeClusterSecret sets the secret shared by the replicas. Forwarded requests are signed with it,
+// the replicas skip the rate limiting only for forwarded requests with a valid signature.
+func WithServeClusterSecret(secret []byte) ServeOption {
+	return func(s *Serve) {
+		s.clusterSigner = NewHMACSigner(secret)
+	}
+}
+
+func WithServeForwardTransport(transport http.RoundTripper) ServeOption {
+	return func(s *Serve) {
+		s.forwardTransport = transport
+	}
+}
+
 type ProxyHandler interface {
 	EventHandler
 	ProxyRequest(conn *Conn, w http.ResponseWriter, r *http.Request) error
@@ -51,19 +276,54 @@ type ProxyHandler interface {
 
 func NewServe(parent context.Context, handler ProxyHandler, codec Codec[*message.Message], opts ...ServeOption) *Serve {
 	serve := &Serve{
-		pool:            NewPool(),
-		parent:          parent,
-		handler:         handler,
-		codec:           codec,
-		logger:          slog.Default(),
-		requireClientId: true,
+		pool:              NewPool(),
+		parent:            parent,
+		handler:           handler,
+		codec:             codec,
+		subprotocolCodecs: make(map[string]Codec[*message.Message]),
+		logger:            slog.Default(),
+		requireClientId:   true,
+		balancer:          NewRandomBalancer(),
+		resolver:          NewHeaderResolver(HeaderClientId),
+		registryTTL:       defaultRegistryTTL,
+		retryPolicy:       DefaultRetryPolicy(),
+		upgrader:          newUpgrader(),
+		outboxMaxAttempts: defaultOutboxMaxAttempts,
 	}
 	for _, opt := range opts {
 		opt(serve)
 	}
+	if subprotocol := Subprotocol(codec); subprotocol != "" {
+		serve.addSubprotocolCodec(subprotocol, codec)
+	}
+	serve.upgrader.Subprotocols = append(slices.Clone(serve.subprotocols), serve.extraSubprotocols...)
+	serve.upgrader.EnableCompression = serve.compression
+	codecs := []Codec[*message.Message]{serve.codec}
+	for _, subprotocol := range serve.subprotocols {
+		codecs = append(codecs, serve.subprotocolCodecs[subprotocol])
+	}
+	serve.connConfig = connConfigOrDefault(serve.connConfig, serve.logger)
+	serve.capabilities = newCapabilities(serve.connConfig, serve.features, codecs...)
+	if serve.registryTTL/3 <= 0 {
+		serve.logger.Error("invalid registry TTL, using the default", slog.String("ttl", serve.registryTTL.String()))
+		serve.registryTTL = defaultRegistryTTL
+	}
+	if serve.registry != nil {
+		serve.pool.onUnregister = serve.unregisterOwnership
+		go serve.refreshOwnership()
+	}
+	serve.pool.onRegister = serve.onRegister
 	return serve
 }
 
+// RejectedMessages returns the number of messages rejected by the message signing.
+func (s *Serve) RejectedMessages() uint64 {
+	if s.signing == nil {
+		return 0
+	}
+	return s.signing.rejected.Load()
+}
+
 func (s *Serve) GetConnByID(id string) *Conn {
 	return s.pool.GetConnByID(id)
 }
@@ -81,53 +341,281 @@ func (s *Serve) HandleWS(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, fmt.Sprintf("header %s is required", HeaderClientId), http.StatusBadRequest)
 		return
 	}
-	conn, err := upgrader.Upgrade(w, r, nil)
+	labels, err := ParseLabels(r.Header.Get(HeaderLabels))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("header %s is invalid: %v", HeaderLabels, err), http.StatusBadRequest)
+		return
+	}
+	instanceID := r.Header.Get(HeaderInstanceId)
+	res, ok := s.checkConflict(w, r, clientID, instanceID)
+	if !ok {
+		return
+	}
+	defer s.pool.release(res)
+	conn, err := s.upgrader.Upgrade(w, r, s.responseHeader)
 	if err != nil {
 		logger.Error("upgrade failed", slog.String("error", err.Error()))
 		return
 	}
-	handleConn(s.parent, s.pool, clientID, conn, s.handler, s.codec, logger)
+	if s.compression {
+		if err = conn.SetCompressionLevel(s.compressionLevel); err != nil {
+			logger.Warn("set compression level failed", slog.String("error", err.Error()))
+		}
+	}
+	codec := s.codec
+	if c, ok := s.subprotocolCodecs[conn.Subprotocol()]; ok {
+		codec = c
+		logger.Debug("selected subprotocol " + conn.Subprotocol())
+	}
+	wsConn := handleConn(s.parent, s.pool, clientID, labels, conn, connParams{
+		handler:      s.handler,
+		codec:        codec,
+		capabilities: s.capabilities,
+		signing:      s.signing,
+		config:       s.connConfig,
+		instanceID:   instanceID,
+		logger:       logger,
+	})
+	if s.conflictPolicy == ConflictReplaceOldest && clientID != "" {
+		s.replaceOlderInstances(wsConn)
+	}
+}
+
+func (s *Serve) addSubprotocolCodec(subprotocol string, codec Codec[*message.Message]) {
+	if _, ok := s.subprotocolCodecs[subprotocol]; ok {
+		return
+	}
+	s.subprotocols = append(s.subprotocols, subprotocol)
+	s.subprotocolCodecs[subprotocol] = codec
+}
+
+// resolveClientID returns the target client ID and the request to be proxied.
+// The requests forwarded by other replicas are resolved the same way, the forwarding replica keeps the request
+// before the resolution and forwards it.
+func (s *Serve) resolveClientID(r *http.Request) (string, *http.Request, error) {
+	clientID, resolved, err := s.resolver.ResolveClientID(r)
+	if err != nil {
+		return "", nil, fmt.Errorf("client ID resolution failed: %w", err)
+	}
+	if s.registry != nil {
+		resolved = withUnresolved(resolved, r)
+	}
+	return clientID, s.authenticateForward(resolved, clientID), nil
+}
+
+// matchConns returns connections matching the client ID, the optional instance ID and the optional label selector of the request.
+func (s *Serve) matchConns(r *http.Request, clientID string) ([]*Conn, error) {
+	var conns []*Conn
+	if selectorValue := r.Header.Get(HeaderSelector); selectorValue == "" {
+		conns = s.GetConnsByID(clientID)
+	} else {
+		selector, err := ParseLabels(selectorValue)
+		if err != nil {
+			return nil, fmt.Errorf("header %s is invalid: %w", HeaderSelector, err)
+		}
+		labelSelector := LabelSelector(selector)
+		conns = s.pool.GetConns(func(conn *Conn) bool {
+			return (clientID == "" || conn.clientID == clientID) && labelSelector(conn)
+		})
+	}
+	if instanceID := r.Header.Get(HeaderInstanceId); instanceID != "" {
+		conns = slices.DeleteFunc(conns, func(conn *Conn) bool {
+			return conn.instanceID != instanceID
+		})
+	}
+	return conns, nil
 }
 
 func (s *Serve) HandleProxy(w http.ResponseWriter, r *http.Request) {
-	clientID := r.Header.Get(HeaderClientId)
-	conn := s.GetConnByID(clientID)
-	if conn == nil {
-		msg := fmt.Sprintf("connection for clientID='%s' not found", clientID)
-		s.logger.Error(msg)
-		http.Error(w, msg, http.StatusUnprocessableEntity)
+	clientID, r, err := s.resolveClientID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !s.allowRequest(w, r, clientID) {
 		return
 	}
-	err := s.handler.ProxyRequest(conn, w, r)
+	if r, err = s.withRequestPriority(r); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	conns, err := s.matchConns(r, clientID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	conn := s.balancer.Pick(conns)
+	if conn == nil {
+		if conn = s.handleConnNotFound(w, r, clientID); conn == nil {
+			return
+		}
+	}
+	err = s.handler.ProxyRequest(conn, w, r)
 	if err != nil {
-		s.logger.Error("proxy request failed", slog.String("error", err.Error()))
-		http.Error(w, err.Error(), http.StatusBadGateway)
+		s.writeProxyError(w, err)
 		return
 	}
 }
 
-func (s *Serve) HandleProxyWithRetry(w http.ResponseWriter, r *http.Request) {
-	clientID := r.Header.Get(HeaderClientId)
-	conns := s.GetConnsByID(clientID)
-	if len(conns) == 0 {
-		msg := fmt.Sprintf("connection for clientID='%s' not found", clientID)
-		s.logger.Error(msg)
-		http.Error(w, msg, http.StatusUnprocessableEntity)
+// writeProxyError responds with the status of the peer error or with 502.
+// Peer errors which are not client or server errors are responded with 502.
+func (s *Serve) writeProxyError(w http.ResponseWriter, err error) {
+	s.logger.Error("proxy request failed", slog.String("error", err.Error()))
+	var peerErr *PeerError
+	if errors.As(err, &peerErr) {
+		code := peerErr.Code
+		if code < 400 || code > 599 {
+			code = http.StatusBadGateway
+		}
+		http.Error(w, peerErr.Message, code)
 		return
 	}
-	var err error
-	for _, conn := range conns {
-		err = s.handler.ProxyRequest(conn, w, r)
-		if err == nil {
-			return
+	if errors.Is(err, ErrQueueFull) {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusBadGateway)
+}
+
+// handleConnNotFound forwards the request to the owning replica or waits for the agent to connect.
+// It returns the connection registered while waiting or nil if the response was written.
+func (s *Serve) handleConnNotFound(w http.ResponseWriter, r *http.Request, clientID string) *Conn {
+	selector := r.Header.Get(HeaderSelector)
+	// the registry does not know the labels
+	if selector == "" && s.forwardProxy(w, r, clientID) {
+		return nil
+	}
+	if s.agentWait > 0 {
+		if conn := s.waitConn(r, clientID); conn != nil {
+			return conn
 		}
-		if errors.Is(err, ErrConnectionClosed) {
-			continue
+	}
+	msg := fmt.Sprintf("connection for clientID='%s' not found", clientID)
+	if selector != "" {
+		msg = fmt.Sprintf("connection for clientID='%s' selector='%s' not found", clientID, selector)
+	}
+	s.logger.Error(msg)
+	http.Error(w, msg, http.StatusUnprocessableEntity)
+	return nil
+}
+
+// waitConn waits until a connection matching the request is registered, at most for the agent wait
+// or the request timeout, whichever is shorter.
+func (s *Serve) waitConn(r *http.Request, clientID string) *Conn {
+	wait := s.agentWait
+	if timeout, err := time.ParseDuration(r.Header.Get(HeaderRequestTimeout)); err == nil && timeout > 0 {
+		wait = min(wait, timeout)
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	for {
+		// take the channel before matching, so no registration is missed
+		registered := s.pool.registered()
+		conns, err := s.matchConns(r, clientID)
+		if err != nil {
+			return nil
 		}
+		if conn := s.balancer.Pick(conns); conn != nil {
+			return conn
+		}
+		select {
+		case <-registered:
+		case <-timer.C:
+			return nil
+		case <-r.Context().Done():
+			return nil
+		}
+	}
+}
+
+func (s *Serve) forwardProxy(w http.ResponseWriter, r *http.Request, clientID string) bool {
+	if s.registry == nil {
+		return false
+	}
+	// forward only once, the owner replica must not forward it further
+	if isForwarded(r) {
+		s.logger.Warn("forwarded request cannot be handled", slog.String("client-id", clientID))
+		return false
 	}
+	owners, err := s.registry.Lookup(r.Context(), clientID)
 	if err != nil {
-		s.logger.Error("proxy request failed", slog.String("error", err.Error()))
-		http.Error(w, err.Error(), http.StatusBadGateway)
+		s.logger.Error("registry lookup failed", slog.String("client-id", clientID), slog.String("error", err.Error()))
+		return false
+	}
+	for _, owner := range owners {
+		if owner == s.advertiseURL {
+			continue
+		}
+		target, err := url.Parse(owner)
+		if err != nil {
+			s.logger.Error("invalid owner url", slog.String("owner", owner), slog.String("error", err.Error()))
+			continue
+		}
+		s.logger.Debug("forwarding request", slog.String("client-id", clientID), slog.String("owner", owner))
+
+		proxy := httputil.NewSingleHostReverseProxy(target)
+		proxy.Transport = s.forwardTransport
+		proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+			s.logger.Error("forward request failed", slog.String("owner", owner), slog.String("error", err.Error()))
+			http.Error(w, err.Error(), http.StatusBadGateway)
+		}
+		forward := unresolved(r)
+		s.signForward(forward, clientID)
+		proxy.ServeHTTP(w, forward)
+		return true
+	}
+	return false
+}
+
+func (s *Serve) onRegister(conn *Conn, count int) {
+	if s.registry != nil {
+		s.registerOwnership(conn, count)
+	}
+	if s.outbox != nil {
+		s.deliverOutbox(conn.clientID)
+	}
+}
+
+func (s *Serve) registerOwnership(conn *Conn, count int) {
+	if count != 1 {
 		return
 	}
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(s.parent), registryTimeout)
+	defer cancel()
+	if err := s.registry.Register(ctx, conn.clientID, s.advertiseURL, s.registryTTL); err != nil {
+		conn.logger.Error("registry register failed", slog.String("error", err.Error()))
+	}
+}
+
+func (s *Serve) unregisterOwnership(conn *Conn, count int) {
+	if count != 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(s.parent), registryTimeout)
+	defer cancel()
+	if err := s.registry.Unregister(ctx, conn.clientID, s.advertiseURL); err != nil {
+		conn.logger.Error("registry unregister failed", slog.String("error", err.Error()))
+	}
+}
+
+func (s *Serve) refreshOwnership() {
+	ticker := time.NewTicker(s.registryTTL / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.parent.Done():
+			return
+		case <-ticker.C:
+			for _, clientID := range s.pool.clientIDs() {
+				// the last connection may have been unregistered meanwhile
+				s.pool.withRegistered(clientID, func() {
+					ctx, cancel := context.WithTimeout(s.parent, registryTimeout)
+					defer cancel()
+					if err := s.registry.Register(ctx, clientID, s.advertiseURL, s.registryTTL); err != nil {
+						s.logger.Error("registry refresh failed", slog.String("client-id", clientID), slog.String("error", err.Error()))
+					}
+				})
+			}
+		}
+	}
 }
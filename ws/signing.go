@@ -0,0 +1,194 @@
+package ws
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/grepplabs/backstream/internal/message"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	defaultReplayWindow = 30 * time.Second
+	// number of rejected messages after which the connection is closed.
+	maxRejectedMessages = 10
+)
+
+// signingRole is the role of the message sender, it is signed with the message,
+// so a message cannot be reflected back to its sender.
+type signingRole byte
+
+const (
+	roleProxy signingRole = 'p'
+	roleAgent signingRole = 'a'
+)
+
+func (r signingRole) peer() signingRole {
+	if r == roleProxy {
+		return roleAgent
+	}
+	return roleProxy
+}
+
+var (
+	ErrInvalidSignature = errors.New("invalid message signature")
+	ErrMessageExpired   = errors.New("message timestamp outside of the replay window")
+	ErrMessageReplayed  = errors.New("message nonce already seen")
+)
+
+// Signer signs the outgoing message envelopes and verifies the signatures of the incoming ones.
+type Signer interface {
+	Sign(data []byte) ([]byte, error)
+	Verify(data []byte, signature []byte) error
+}
+
+type hmacSigner struct {
+	key []byte
+}
+
+// NewHMACSigner signs the messages with HMAC-SHA256, both sides share the key.
+func NewHMACSigner(key []byte) Signer {
+	return &hmacSigner{
+		key: key,
+	}
+}
+
+func (s *hmacSigner) Sign(data []byte) ([]byte, error) {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write(data)
+	return mac.Sum(nil), nil
+}
+
+func (s *hmacSigner) Verify(data []byte, signature []byte) error {
+	expected, _ := s.Sign(data)
+	if !hmac.Equal(expected, signature) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+type ed25519Signer struct {
+	privateKey ed25519.PrivateKey
+	publicKeys []ed25519.PublicKey
+}
+
+// NewEd25519Signer signs the messages with the private key and accepts messages signed by any of the peer public keys.
+func NewEd25519Signer(privateKey ed25519.PrivateKey, peerKeys ...ed25519.PublicKey) Signer {
+	return &ed25519Signer{
+		privateKey: privateKey,
+		publicKeys: peerKeys,
+	}
+}
+
+func (s *ed25519Signer) Sign(data []byte) ([]byte, error) {
+	return ed25519.Sign(s.privateKey, data), nil
+}
+
+func (s *ed25519Signer) Verify(data []byte, signature []byte) error {
+	for _, publicKey := range s.publicKeys {
+		if ed25519.Verify(publicKey, data, signature) {
+			return nil
+		}
+	}
+	return ErrInvalidSignature
+}
+
+// messageSigning signs the messages and rejects the unsigned, tampered and replayed ones.
+// A message is accepted if its timestamp is within the window and its nonce was not seen within the window.
+type messageSigning struct {
+	signer Signer
+	window time.Duration
+	// role of this side
+	role     signingRole
+	rejected atomic.Uint64
+
+	mu     sync.Mutex
+	nonces map[string]time.Time
+	pruned time.Time
+}
+
+func newMessageSigning(signer Signer, window time.Duration, role signingRole) *messageSigning {
+	if window <= 0 {
+		window = defaultReplayWindow
+	}
+	return &messageSigning{
+		signer: signer,
+		window: window,
+		role:   role,
+		nonces: make(map[string]time.Time),
+	}
+}
+
+func (s *messageSigning) sign(msg *message.Message) error {
+	msg.Timestamp = time.Now().UnixMilli()
+	msg.Nonce = uuid.New().String()
+	msg.Signature = nil
+	data, err := signedData(msg, s.role)
+	if err != nil {
+		return err
+	}
+	msg.Signature, err = s.signer.Sign(data)
+	return err
+}
+
+func (s *messageSigning) verify(msg *message.Message) error {
+	err := s.doVerify(msg)
+	if err != nil {
+		s.rejected.Add(1)
+	}
+	return err
+}
+
+func (s *messageSigning) doVerify(msg *message.Message) error {
+	if len(msg.Signature) == 0 {
+		return fmt.Errorf("%w: message is not signed", ErrInvalidSignature)
+	}
+	signature := msg.Signature
+	msg.Signature = nil
+	data, err := signedData(msg, s.role.peer())
+	msg.Signature = signature
+	if err != nil {
+		return err
+	}
+	if err = s.signer.Verify(data, signature); err != nil {
+		return err
+	}
+	now := time.Now()
+	timestamp := time.UnixMilli(msg.Timestamp)
+	if timestamp.Before(now.Add(-s.window)) || timestamp.After(now.Add(s.window)) {
+		return ErrMessageExpired
+	}
+	return s.checkNonce(msg.Nonce, now)
+}
+
+func (s *messageSigning) checkNonce(nonce string, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if now.Sub(s.pruned) > s.window {
+		for n, expires := range s.nonces {
+			if now.After(expires) {
+				delete(s.nonces, n)
+			}
+		}
+		s.pruned = now
+	}
+	if expires, ok := s.nonces[nonce]; ok && !now.After(expires) {
+		return ErrMessageReplayed
+	}
+	// a nonce must be remembered as long as its message timestamp can be accepted
+	s.nonces[nonce] = now.Add(2 * s.window)
+	return nil
+}
+
+// signedData returns the sender role followed by the deterministic encoding of the message envelope without the signature.
+func signedData(msg *message.Message, role signingRole) ([]byte, error) {
+	return proto.MarshalOptions{Deterministic: true}.MarshalAppend([]byte{byte(role)}, msg)
+}
@@ -0,0 +1,123 @@
+package ws
+
+import (
+	"context"
+	"crypto/ed25519"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grepplabs/backstream/internal/message"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestMessageSigningVerify(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	otherPublicKey, _, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+
+	signers := map[string][2]Signer{
+		"hmac":    {NewHMACSigner([]byte("secret")), NewHMACSigner([]byte("secret"))},
+		"ed25519": {NewEd25519Signer(privateKey), NewEd25519Signer(nil, otherPublicKey, publicKey)},
+	}
+	for name, pair := range signers {
+		t.Run(name, func(t *testing.T) {
+			sender := newMessageSigning(pair[0], time.Minute, roleAgent)
+			receiver := newMessageSigning(pair[1], time.Minute, roleProxy)
+
+			msg := &message.Message{Id: "1", Type: message.Message_NOTIFY, Data: []byte("hello")}
+			require.NoError(t, sender.sign(msg))
+			require.NotEmpty(t, msg.Nonce)
+			require.NotZero(t, msg.Timestamp)
+			require.NoError(t, receiver.verify(proto.Clone(msg).(*message.Message)))
+
+			require.ErrorIs(t, receiver.verify(proto.Clone(msg).(*message.Message)), ErrMessageReplayed)
+
+			tampered := proto.Clone(msg).(*message.Message)
+			tampered.Data = []byte("world")
+			require.ErrorIs(t, receiver.verify(tampered), ErrInvalidSignature)
+
+			unsigned := &message.Message{Id: "2", Type: message.Message_NOTIFY}
+			require.ErrorIs(t, receiver.verify(unsigned), ErrInvalidSignature)
+
+			expired := &message.Message{Id: "3", Type: message.Message_NOTIFY}
+			require.NoError(t, sender.sign(expired))
+			expired.Timestamp = time.Now().Add(-2 * time.Minute).UnixMilli()
+			expired.Signature = nil
+			expired.Signature, err = pair[0].Sign(mustSignedData(t, expired, roleAgent))
+			require.NoError(t, err)
+			require.ErrorIs(t, receiver.verify(expired), ErrMessageExpired)
+
+			// a message cannot be reflected back to its sender
+			reflected := &message.Message{Id: "4", Type: message.Message_NOTIFY}
+			require.NoError(t, newMessageSigning(pair[0], time.Minute, roleProxy).sign(reflected))
+			require.ErrorIs(t, receiver.verify(reflected), ErrInvalidSignature)
+
+			require.Equal(t, uint64(5), receiver.rejected.Load())
+		})
+	}
+
+	_, otherPrivateKey, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	msg := &message.Message{Id: "1", Type: message.Message_NOTIFY}
+	require.NoError(t, newMessageSigning(NewEd25519Signer(otherPrivateKey), 0, roleAgent).sign(msg))
+	require.ErrorIs(t, newMessageSigning(NewEd25519Signer(nil, publicKey), 0, roleProxy).verify(msg), ErrInvalidSignature)
+}
+
+func mustSignedData(t *testing.T, msg *message.Message, role signingRole) []byte {
+	data, err := signedData(msg, role)
+	require.NoError(t, err)
+	return data
+}
+
+func TestMessageSigning(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	codec := NewJsonCodec[*message.Message]()
+	proxyHandler := &noopProxyHandler{}
+	serve := NewServe(ctx, proxyHandler, codec, WithServeMessageSigning(NewHMACSigner([]byte("secret")), time.Minute))
+	server := httptest.NewServer(http.HandlerFunc(serve.HandleWS))
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	agent := &notifyRecorder{}
+	client := NewClient(ctx, url, agent, codec, WithClientID("4711"), WithClientMessageSigning(NewHMACSigner([]byte("secret")), time.Minute))
+	conn, err := client.GetConn()
+	require.NoError(t, err)
+	require.Equal(t, ProtocolVersion, conn.Capabilities().Version)
+
+	require.NoError(t, serve.GetConnByID("4711").Notify(ctx, []byte("to agent")))
+	require.NoError(t, conn.Notify(ctx, []byte("to proxy")))
+	require.Eventually(t, func() bool {
+		return len(agent.Received()) == 1 && len(proxyHandler.Received()) == 1
+	}, 3*time.Second, 10*time.Millisecond)
+	require.Zero(t, serve.RejectedMessages())
+	require.Zero(t, client.RejectedMessages())
+
+	// messages of an agent with a different key are rejected, including the handshake
+	rogue := NewClient(ctx, url, &notifyRecorder{}, codec, WithClientID("4712"), WithClientMessageSigning(NewHMACSigner([]byte("other")), time.Minute), WithClientHandshakeTimeout(100*time.Millisecond))
+	rogueConn, err := rogue.GetConn()
+	require.NoError(t, err)
+	rogueServerConn := serve.GetConnByID("4712")
+	require.NotNil(t, rogueServerConn)
+	require.NoError(t, rogueConn.Notify(ctx, []byte("rogue")))
+	require.Eventually(t, func() bool {
+		return serve.RejectedMessages() == 2
+	}, 3*time.Second, 10*time.Millisecond)
+	require.Equal(t, []string{"to proxy"}, proxyHandler.Received())
+
+	// the connection is closed after too many rejected messages
+	for i := 2; i < maxRejectedMessages; i++ {
+		_ = rogueConn.Notify(ctx, []byte("rogue"))
+	}
+	select {
+	case <-rogueServerConn.done:
+	case <-time.After(3 * time.Second):
+		require.Fail(t, "connection not closed")
+	}
+}
@@ -0,0 +1,58 @@
+package ws
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	defaultReadBufferSize  = 1024
+	defaultWriteBufferSize = 1024
+)
+
+func newUpgrader() websocket.Upgrader {
+	return websocket.Upgrader{
+		ReadBufferSize:  defaultReadBufferSize,
+		WriteBufferSize: defaultWriteBufferSize,
+		CheckOrigin: func(r *http.Request) bool {
+			return true
+		},
+	}
+}
+
+// allowedOrigins accepts requests without the Origin header, which are sent by the agents,
+// and requests with an allowed origin. The host of an allowed origin can start with the *. wildcard.
+func allowedOrigins(origins []string) func(r *http.Request) bool {
+	return func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			return true
+		}
+		u, err := url.Parse(origin)
+		if err != nil {
+			return false
+		}
+		for _, allowed := range origins {
+			if matchOrigin(u, allowed) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+func matchOrigin(origin *url.URL, allowed string) bool {
+	a, err := url.Parse(allowed)
+	if err != nil || !strings.EqualFold(origin.Scheme, a.Scheme) {
+		return false
+	}
+	if suffix, ok := strings.CutPrefix(a.Host, "*."); ok {
+		host := strings.ToLower(origin.Host)
+		suffix = strings.ToLower(suffix)
+		return strings.HasSuffix(host, "."+suffix) && len(host) > len(suffix)+1
+	}
+	return strings.EqualFold(origin.Host, a.Host)
+}
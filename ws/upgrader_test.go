@@ -0,0 +1,83 @@
+package ws
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/grepplabs/backstream/internal/message"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAllowedOrigins(t *testing.T) {
+	check := allowedOrigins([]string{"https://app.example.com", "https://*.example.org"})
+	tests := []struct {
+		origin  string
+		allowed bool
+	}{
+		{origin: "", allowed: true},
+		{origin: "https://app.example.com", allowed: true},
+		{origin: "https://APP.example.com", allowed: true},
+		{origin: "http://app.example.com", allowed: false},
+		{origin: "https://evil.example.com", allowed: false},
+		{origin: "https://a.example.org", allowed: true},
+		{origin: "https://a.b.example.org", allowed: true},
+		{origin: "https://example.org", allowed: false},
+		{origin: "https://evilexample.org", allowed: false},
+	}
+	for _, tc := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/ws", nil)
+		if tc.origin != "" {
+			r.Header.Set("Origin", tc.origin)
+		}
+		require.Equal(t, tc.allowed, check(r), tc.origin)
+	}
+}
+
+func TestServeUpgrader(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	codec := NewProtoCodec[*message.Message]()
+	serve := NewServe(ctx, &noopProxyHandler{}, codec,
+		WithServeAllowedOrigins("https://app.example.com"),
+		WithServeBufferSizes(4096, 4096),
+		WithServeWriteBufferPool(&sync.Pool{}),
+		WithServeSubprotocols("gateway.v1"),
+		WithServeResponseHeader(http.Header{"Set-Cookie": {"route=a"}}),
+	)
+	server := httptest.NewServer(http.HandlerFunc(serve.HandleWS))
+	defer server.Close()
+	serverURL := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	_, err := NewClient(ctx, serverURL, &notifyRecorder{}, codec, WithClientID("4711"),
+		WithClientHeader(http.Header{"Origin": {"https://evil.example.com"}})).GetConn()
+	require.ErrorContains(t, err, "status: 403")
+
+	var cookie atomic.Value
+	conn, err := NewClient(ctx, serverURL, &notifyRecorder{}, codec, WithClientID("4711"),
+		WithClientHeader(http.Header{"Origin": {"https://app.example.com"}}),
+		WithClientUpgradeHook(func(resp *http.Response) error {
+			cookie.Store(resp.Header.Get("Set-Cookie"))
+			return nil
+		})).GetConn()
+	require.NoError(t, err)
+	require.Equal(t, SubprotocolProtoV1, conn.Subprotocol())
+	require.Equal(t, "route=a", cookie.Load())
+
+	// the gateway subprotocol uses the default codec
+	dialer := &websocket.Dialer{Subprotocols: []string{"gateway.v1"}}
+	wsConn, _, err := dialer.DialContext(ctx, serverURL, http.Header{HeaderClientId: {"4712"}})
+	require.NoError(t, err)
+	defer wsConn.Close()
+	require.Equal(t, "gateway.v1", wsConn.Subprotocol())
+	require.Eventually(t, func() bool {
+		return serve.GetConnByID("4712") != nil
+	}, time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,100 @@
+package ws
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grepplabs/backstream/internal/message"
+	"github.com/stretchr/testify/require"
+)
+
+type clientIDProxyHandler struct {
+	notifyRecorder
+}
+
+func (h *clientIDProxyHandler) ProxyRequest(conn *Conn, w http.ResponseWriter, _ *http.Request) error {
+	_, _ = w.Write([]byte(conn.ClientID()))
+	return nil
+}
+
+func TestPoolRegistered(t *testing.T) {
+	pool := NewPool()
+	registered := pool.registered()
+	select {
+	case <-registered:
+		t.Fatal("unexpected registration")
+	default:
+	}
+	pool.register(&Conn{clientID: "4711"})
+	select {
+	case <-registered:
+	default:
+		t.Fatal("registration not notified")
+	}
+	require.NotEqual(t, registered, pool.registered())
+}
+
+func TestServeAgentWait(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	codec := NewProtoCodec[*message.Message]()
+	serve := NewServe(ctx, &clientIDProxyHandler{}, codec, WithServeAgentWait(3*time.Second))
+	server := httptest.NewServer(http.HandlerFunc(serve.HandleWS))
+	defer server.Close()
+
+	for _, handle := range []http.HandlerFunc{serve.HandleProxy, serve.HandleProxyWithRetry} {
+		clientID := "4711"
+		if serve.GetConnByID(clientID) != nil {
+			clientID = "4712"
+		}
+		done := make(chan *httptest.ResponseRecorder)
+		go func() {
+			r := httptest.NewRequest(http.MethodGet, "/test", nil)
+			r.Header.Set(HeaderClientId, clientID)
+			w := httptest.NewRecorder()
+			handle(w, r)
+			done <- w
+		}()
+		time.Sleep(100 * time.Millisecond)
+		// other agent does not complete the request
+		_, err := NewClient(ctx, "ws"+strings.TrimPrefix(server.URL, "http"), &notifyRecorder{}, codec, WithClientID("other-"+clientID)).GetConn()
+		require.NoError(t, err)
+		select {
+		case <-done:
+			t.Fatal("request completed without the agent")
+		case <-time.After(100 * time.Millisecond):
+		}
+
+		_, err = NewClient(ctx, "ws"+strings.TrimPrefix(server.URL, "http"), &notifyRecorder{}, codec, WithClientID(clientID)).GetConn()
+		require.NoError(t, err)
+		w := <-done
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, clientID, w.Body.String())
+	}
+
+	// request canceled by the caller
+	reqCtx, reqCancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer reqCancel()
+	r := httptest.NewRequest(http.MethodGet, "/test", nil).WithContext(reqCtx)
+	r.Header.Set(HeaderClientId, "4713")
+	w := httptest.NewRecorder()
+	start := time.Now()
+	serve.HandleProxy(w, r)
+	require.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	require.Less(t, time.Since(start), time.Second)
+
+	// the request timeout is shorter than the agent wait
+	r = httptest.NewRequest(http.MethodGet, "/test", nil)
+	r.Header.Set(HeaderClientId, "4713")
+	r.Header.Set(HeaderRequestTimeout, "100ms")
+	w = httptest.NewRecorder()
+	start = time.Now()
+	serve.HandleProxy(w, r)
+	require.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	require.Less(t, time.Since(start), time.Second)
+}